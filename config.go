@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultOAuthRedirectURL is the callback registered with the Bitbucket application link unless
+// configured otherwise
+const defaultOAuthRedirectURL = "http://127.0.0.1:8765/callback"
+
+// profileName restricts profile names to what is safe in file names and tool arguments
+var profileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// configFile is the optional YAML file with named profiles, one per Bitbucket instance
+type configFile struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*profile `yaml:"profiles"`
+}
+
+// profile holds the settings of one Bitbucket instance. The environment variables override the
+// settings of the default profile.
+type profile struct {
+	BaseURL        string       `yaml:"base_url"`
+	Username       string       `yaml:"username"`
+	Password       string       `yaml:"password"`
+	Token          string       `yaml:"token"`
+	TokenFile      string       `yaml:"token_file"`
+	TokenCommand   string       `yaml:"token_command"`
+	OAuth          oauthProfile `yaml:"oauth"`
+	DefaultProject string       `yaml:"default_project"`
+	ReadOnly       bool         `yaml:"read_only"`
+	TLS            tlsProfile   `yaml:"tls"`
+	Proxy          string       `yaml:"proxy"`
+}
+
+type oauthProfile struct {
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	TokenFile    string   `yaml:"token_file"`
+	AuthorizeURL string   `yaml:"authorize_url"`
+	TokenURL     string   `yaml:"token_url"`
+}
+
+type tlsProfile struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// getBitbucketConfigs returns the client configuration of every profile and the name of the
+// default one. Without a configuration file there is a single profile, "default", configured by
+// the environment variables alone.
+func getBitbucketConfigs(userTokens bool) (map[string]*bitbucket.Config, string) {
+	profiles, defaultName, fromFile := loadProfiles()
+
+	configs := make(map[string]*bitbucket.Config, len(profiles))
+	for name, p := range profiles {
+		config, err := p.bitbucketConfig(name, fromFile, userTokens)
+		if err != nil {
+			if fromFile {
+				log.Fatalf("Invalid profile %q: %v", name, err)
+			}
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		configs[name] = config
+	}
+	return configs, defaultName
+}
+
+// loadProfiles reads the configuration file named by BITBUCKET_CONFIG, or
+// ~/.config/bbcli/config.yaml if it exists, and applies the environment variables to the profile
+// selected by BITBUCKET_PROFILE or the file's default
+func loadProfiles() (map[string]*profile, string, bool) {
+	path := os.Getenv("BITBUCKET_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "bbcli", "config.yaml")
+		}
+	}
+
+	var file configFile
+	data, err := os.ReadFile(path)
+	switch {
+	case path == "" || (errors.Is(err, os.ErrNotExist) && !explicit):
+		p := &profile{}
+		p.applyEnv()
+		return map[string]*profile{"default": p}, "default", false
+	case err != nil:
+		log.Fatalf("Cannot read configuration file: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		log.Fatalf("Invalid configuration file %s: %v", path, err)
+	}
+	if len(file.Profiles) == 0 {
+		log.Fatalf("Configuration file %s defines no profiles", path)
+	}
+
+	names := make([]string, 0, len(file.Profiles))
+	// Header names are case-insensitive, so X-Bitbucket-Token-<profile> could not tell these apart
+	folded := make(map[string]string, len(file.Profiles))
+	for name, p := range file.Profiles {
+		if !profileName.MatchString(name) {
+			log.Fatalf("Invalid profile name %q in %s: use letters, digits, - and _", name, path)
+		}
+		if other, ok := folded[strings.ToLower(name)]; ok {
+			log.Fatalf("Profile names %q and %q in %s differ only in case", other, name, path)
+		}
+		folded[strings.ToLower(name)] = name
+		if p == nil {
+			p = &profile{}
+			file.Profiles[name] = p
+		}
+		p.expandPaths()
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defaultName := os.Getenv("BITBUCKET_PROFILE")
+	if defaultName == "" {
+		defaultName = file.Default
+	}
+	if defaultName == "" && len(names) == 1 {
+		defaultName = names[0]
+	}
+	if defaultName == "" {
+		log.Fatalf("Set default in %s or BITBUCKET_PROFILE to one of: %s", path, strings.Join(names, ", "))
+	}
+	if file.Profiles[defaultName] == nil {
+		log.Fatalf("Unknown profile %q: %s defines %s", defaultName, path, strings.Join(names, ", "))
+	}
+
+	file.Profiles[defaultName].applyEnv()
+	return file.Profiles, defaultName, true
+}
+
+// applyEnv overrides the profile with the environment variables that are set. Credentials from
+// the environment replace those of the profile rather than mixing with them.
+func (p *profile) applyEnv() {
+	for _, name := range []string{"BITBUCKET_TOKEN", "BITBUCKET_TOKEN_FILE", "BITBUCKET_TOKEN_COMMAND", "BITBUCKET_USERNAME", "BITBUCKET_PASSWORD", "BITBUCKET_OAUTH_CLIENT_ID"} {
+		if os.Getenv(name) != "" {
+			p.Username, p.Password, p.Token, p.TokenFile, p.TokenCommand = "", "", "", "", ""
+			p.OAuth = oauthProfile{}
+			break
+		}
+	}
+
+	setFromEnv(&p.BaseURL, "BITBUCKET_BASE_URL")
+	setFromEnv(&p.Username, "BITBUCKET_USERNAME")
+	setFromEnv(&p.Password, "BITBUCKET_PASSWORD")
+	setFromEnv(&p.Token, "BITBUCKET_TOKEN")
+	setFromEnv(&p.TokenFile, "BITBUCKET_TOKEN_FILE")
+	setFromEnv(&p.TokenCommand, "BITBUCKET_TOKEN_COMMAND")
+	setFromEnv(&p.OAuth.ClientID, "BITBUCKET_OAUTH_CLIENT_ID")
+	setFromEnv(&p.OAuth.ClientSecret, "BITBUCKET_OAUTH_CLIENT_SECRET")
+	setFromEnv(&p.OAuth.RedirectURL, "BITBUCKET_OAUTH_REDIRECT_URL")
+	setFromEnv(&p.OAuth.TokenFile, "BITBUCKET_OAUTH_TOKEN_FILE")
+	setFromEnv(&p.OAuth.AuthorizeURL, "BITBUCKET_OAUTH_AUTHORIZE_URL")
+	setFromEnv(&p.OAuth.TokenURL, "BITBUCKET_OAUTH_TOKEN_URL")
+	if scopes := strings.FieldsFunc(os.Getenv("BITBUCKET_OAUTH_SCOPES"), isScopeSeparator); len(scopes) > 0 {
+		p.OAuth.Scopes = scopes
+	}
+	setFromEnv(&p.DefaultProject, "BITBUCKET_DEFAULT_PROJECT_KEY")
+	if value := os.Getenv("BITBUCKET_READ_ONLY"); value != "" {
+		p.ReadOnly = isTruthy(value)
+	}
+	setFromEnv(&p.TLS.CAFile, "BITBUCKET_CA_FILE")
+	setFromEnv(&p.TLS.CertFile, "BITBUCKET_CLIENT_CERT")
+	setFromEnv(&p.TLS.KeyFile, "BITBUCKET_CLIENT_KEY")
+	if value := os.Getenv("BITBUCKET_INSECURE_SKIP_VERIFY"); value != "" {
+		p.TLS.InsecureSkipVerify = isTruthy(value)
+	}
+	setFromEnv(&p.Proxy, "BITBUCKET_PROXY")
+}
+
+// expandPaths resolves a leading ~/ in the file settings of a profile
+func (p *profile) expandPaths() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	for _, path := range []*string{&p.TokenFile, &p.OAuth.TokenFile, &p.TLS.CAFile, &p.TLS.CertFile, &p.TLS.KeyFile} {
+		if rest, ok := strings.CutPrefix(*path, "~/"); ok {
+			*path = filepath.Join(home, rest)
+		}
+	}
+}
+
+func setFromEnv(field *string, name string) {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		*field = value
+	}
+}
+
+func isScopeSeparator(r rune) bool {
+	return r == ',' || r == ' '
+}
+
+// bitbucketConfig validates the profile and turns it into a client configuration. Setting names
+// in errors are those of the environment variables unless the profile comes from a file.
+func (p *profile) bitbucketConfig(name string, fromFile, userTokens bool) (*bitbucket.Config, error) {
+	setting := func(env, key string) string {
+		if fromFile {
+			return key
+		}
+		return env
+	}
+
+	config := &bitbucket.Config{
+		Profile:           name,
+		BaseURL:           p.BaseURL,
+		Username:          p.Username,
+		Password:          p.Password,
+		Token:             p.Token,
+		DefaultProjectKey: p.DefaultProject,
+		ReadOnly:          p.ReadOnly,
+	}
+
+	var missing []string
+	if config.BaseURL == "" {
+		missing = append(missing, setting("BITBUCKET_BASE_URL", "base_url"))
+	}
+
+	var err error
+	if config.OAuth, err = p.oauthConfig(name, fromFile); err != nil {
+		return nil, err
+	}
+	if config.Credentials, err = p.credentialSource(setting); err != nil {
+		return nil, err
+	}
+	if config.HTTPClient, err = p.httpClient(setting); err != nil {
+		return nil, err
+	}
+
+	if config.Token == "" && config.Credentials == nil && config.OAuth == nil && (config.Username == "" || config.Password == "") {
+		if userTokens {
+			log.Printf("No shared Bitbucket credentials configured for %s; every client must send its own token in the %s header", name, bitbucketTokenHeader)
+		} else if fromFile {
+			missing = append(missing, "token, token_file, token_command, oauth.client_id, both username and password, or a ~/.netrc entry")
+		} else {
+			missing = append(missing, "BITBUCKET_TOKEN, BITBUCKET_TOKEN_FILE, BITBUCKET_TOKEN_COMMAND, BITBUCKET_OAUTH_CLIENT_ID, both BITBUCKET_USERNAME and BITBUCKET_PASSWORD, or a ~/.netrc entry")
+		}
+	}
+
+	if len(missing) > 0 {
+		if fromFile {
+			return nil, fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
+		}
+		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	return config, nil
+}
+
+// oauthConfig returns the OAuth settings, or nil when no client ID is configured. Profiles from
+// a file keep their tokens in separate files.
+func (p *profile) oauthConfig(name string, fromFile bool) (*bitbucket.OAuthConfig, error) {
+	if p.OAuth.ClientID == "" {
+		return nil, nil
+	}
+
+	config := &bitbucket.OAuthConfig{
+		ClientID:     p.OAuth.ClientID,
+		ClientSecret: p.OAuth.ClientSecret,
+		AuthorizeURL: p.OAuth.AuthorizeURL,
+		TokenURL:     p.OAuth.TokenURL,
+		RedirectURL:  p.OAuth.RedirectURL,
+		Scopes:       p.OAuth.Scopes,
+		TokenFile:    p.OAuth.TokenFile,
+	}
+	if config.RedirectURL == "" {
+		config.RedirectURL = defaultOAuthRedirectURL
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"REPO_WRITE"}
+	}
+	if config.TokenFile == "" {
+		if !fromFile {
+			name = ""
+		}
+		tokenFile, err := bitbucket.DefaultOAuthTokenFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot locate the OAuth token file, set BITBUCKET_OAUTH_TOKEN_FILE: %w", err)
+		}
+		config.TokenFile = tokenFile
+	}
+	return config, nil
+}
+
+// credentialSource returns where to load credentials from when they are not in the profile
+// itself: the token file, the token command, or else the netrc entry for the Bitbucket host if
+// nothing else is configured. The netrc file is only read when credentials are first needed, so
+// an unreadable file or a missing entry is reported by the first request.
+func (p *profile) credentialSource(setting func(env, key string) string) (bitbucket.CredentialSource, error) {
+	set := 0
+	for _, value := range []string{p.Token, p.TokenFile, p.TokenCommand} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("set only one of %s, %s and %s",
+			setting("BITBUCKET_TOKEN", "token"), setting("BITBUCKET_TOKEN_FILE", "token_file"), setting("BITBUCKET_TOKEN_COMMAND", "token_command"))
+	}
+
+	switch {
+	case p.TokenFile != "":
+		return bitbucket.TokenFile(p.TokenFile), nil
+	case p.TokenCommand != "":
+		return bitbucket.TokenCommand(p.TokenCommand), nil
+	case p.Token != "" || p.OAuth.ClientID != "" || (p.Username != "" && p.Password != ""):
+		return nil, nil
+	}
+
+	baseURL, err := url.Parse(p.BaseURL)
+	if err != nil || baseURL.Hostname() == "" {
+		return nil, nil
+	}
+	netrcFile, err := bitbucket.DefaultNetrcFile()
+	if err != nil {
+		return nil, nil
+	}
+	info, err := os.Stat(netrcFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read netrc file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("netrc file %s is a directory", netrcFile)
+	}
+	return bitbucket.NetrcCredentials{Path: netrcFile, Host: baseURL.Hostname()}, nil
+}
+
+// httpClient returns a client with the profile's TLS and proxy settings, or nil when it has none
+// and the defaults apply
+func (p *profile) httpClient(setting func(env, key string) string) (*http.Client, error) {
+	if p.TLS == (tlsProfile{}) && p.Proxy == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.TLS.InsecureSkipVerify}
+	if p.TLS.InsecureSkipVerify {
+		log.Printf("Warning: TLS certificate verification is disabled for %s", p.BaseURL)
+	}
+
+	if p.TLS.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(p.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in CA file %s", p.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (p.TLS.CertFile == "") != (p.TLS.KeyFile == "") {
+		return nil, fmt.Errorf("%s and %s must be given together",
+			setting("BITBUCKET_CLIENT_CERT", "tls.cert_file"), setting("BITBUCKET_CLIENT_KEY", "tls.key_file"))
+	}
+	if p.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLS.CertFile, p.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if p.Proxy != "" {
+		proxyURL, err := url.Parse(p.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid %s %q", setting("BITBUCKET_PROXY", "proxy"), p.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// configEnv lists every variable loadProfiles and applyEnv read, cleared before each test
+var configEnv = []string{
+	"BITBUCKET_CONFIG", "BITBUCKET_PROFILE", "BITBUCKET_BASE_URL", "BITBUCKET_USERNAME", "BITBUCKET_PASSWORD",
+	"BITBUCKET_TOKEN", "BITBUCKET_TOKEN_FILE", "BITBUCKET_TOKEN_COMMAND", "BITBUCKET_OAUTH_CLIENT_ID",
+	"BITBUCKET_OAUTH_CLIENT_SECRET", "BITBUCKET_OAUTH_REDIRECT_URL", "BITBUCKET_OAUTH_TOKEN_FILE",
+	"BITBUCKET_OAUTH_AUTHORIZE_URL", "BITBUCKET_OAUTH_TOKEN_URL", "BITBUCKET_OAUTH_SCOPES",
+	"BITBUCKET_DEFAULT_PROJECT_KEY", "BITBUCKET_READ_ONLY", "BITBUCKET_CA_FILE", "BITBUCKET_CLIENT_CERT",
+	"BITBUCKET_CLIENT_KEY", "BITBUCKET_INSECURE_SKIP_VERIFY", "BITBUCKET_PROXY",
+}
+
+const testConfigFile = `
+default: work
+profiles:
+  work:
+    base_url: https://work.example.com
+    username: alice
+    password: secret
+    default_project: WORK
+    read_only: true
+  oss:
+    base_url: https://oss.example.com
+    token: oss-token
+`
+
+func TestLoadProfilesAppliesEnvToDefaultProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		defaultName string
+		want        profile
+	}{
+		{
+			name:        "file only",
+			defaultName: "work",
+			want:        profile{BaseURL: "https://work.example.com", Username: "alice", Password: "secret", DefaultProject: "WORK", ReadOnly: true},
+		},
+		{
+			name:        "settings override the file",
+			env:         map[string]string{"BITBUCKET_DEFAULT_PROJECT_KEY": "OTHER", "BITBUCKET_READ_ONLY": "false"},
+			defaultName: "work",
+			want:        profile{BaseURL: "https://work.example.com", Username: "alice", Password: "secret", DefaultProject: "OTHER"},
+		},
+		{
+			name:        "credentials replace rather than mix",
+			env:         map[string]string{"BITBUCKET_TOKEN": "env-token"},
+			defaultName: "work",
+			want:        profile{BaseURL: "https://work.example.com", Token: "env-token", DefaultProject: "WORK", ReadOnly: true},
+		},
+		{
+			name:        "username alone drops the file password",
+			env:         map[string]string{"BITBUCKET_USERNAME": "bob"},
+			defaultName: "work",
+			want:        profile{BaseURL: "https://work.example.com", Username: "bob", DefaultProject: "WORK", ReadOnly: true},
+		},
+		{
+			name:        "profile selected by BITBUCKET_PROFILE",
+			env:         map[string]string{"BITBUCKET_PROFILE": "oss", "BITBUCKET_BASE_URL": "https://mirror.example.com"},
+			defaultName: "oss",
+			want:        profile{BaseURL: "https://mirror.example.com", Token: "oss-token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range configEnv {
+				t.Setenv(name, "")
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(testConfigFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("BITBUCKET_CONFIG", path)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			profiles, defaultName, fromFile := loadProfiles()
+			if !fromFile || defaultName != tt.defaultName {
+				t.Fatalf("loadProfiles = default %q, from file %v; want %q from the file", defaultName, fromFile, tt.defaultName)
+			}
+			if got := *profiles[defaultName]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("profile %s = %+v, want %+v", defaultName, got, tt.want)
+			}
+
+			// Only the default profile takes the environment
+			other := "oss"
+			want := profile{BaseURL: "https://oss.example.com", Token: "oss-token"}
+			if defaultName == "oss" {
+				other = "work"
+				want = profile{BaseURL: "https://work.example.com", Username: "alice", Password: "secret", DefaultProject: "WORK", ReadOnly: true}
+			}
+			if got := *profiles[other]; !reflect.DeepEqual(got, want) {
+				t.Errorf("profile %s = %+v, want it unchanged as %+v", other, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadProfilesWithoutConfigFile(t *testing.T) {
+	for _, name := range configEnv {
+		t.Setenv(name, "")
+	}
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("APPDATA", dir)
+	t.Setenv("BITBUCKET_BASE_URL", "https://bitbucket.example.com")
+	t.Setenv("BITBUCKET_TOKEN", "env-token")
+	t.Setenv("BITBUCKET_OAUTH_SCOPES", "PROJECT_READ, REPO_WRITE")
+
+	profiles, defaultName, fromFile := loadProfiles()
+	if fromFile || defaultName != "default" || len(profiles) != 1 {
+		t.Fatalf("loadProfiles = %d profiles, default %q, from file %v; want only the default profile from the environment", len(profiles), defaultName, fromFile)
+	}
+	p := profiles["default"]
+	if p.BaseURL != "https://bitbucket.example.com" || p.Token != "env-token" {
+		t.Errorf("profile = %+v", p)
+	}
+	if len(p.OAuth.Scopes) != 2 || p.OAuth.Scopes[0] != "PROJECT_READ" || p.OAuth.Scopes[1] != "REPO_WRITE" {
+		t.Errorf("scopes = %q, want PROJECT_READ and REPO_WRITE", p.OAuth.Scopes)
+	}
+}
@@ -1,13 +1,22 @@
 module bbcli
 
-go 1.23
+go 1.25.5
 
-toolchain go1.24.0
-
-require github.com/mark3labs/mcp-go v0.31.0
+require (
+	github.com/invopop/jsonschema v0.13.0
+	github.com/mark3labs/mcp-go v0.54.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
+	github.com/bahlo/generic-list-go v0.2.0 // indirect
+	github.com/buger/jsonparser v1.1.1 // indirect
+	github.com/google/jsonschema-go v0.4.2 // indirect
 	github.com/google/uuid v1.6.0 // indirect
+	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2 // indirect
 	github.com/spf13/cast v1.7.1 // indirect
+	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+)
+
+// loginTimeout bounds how long `bbcli login` waits for the user to approve access in the browser
+const loginTimeout = 5 * time.Minute
+
+// login runs the OAuth 2.0 authorization code flow for `bbcli login [profile]` and stores the
+// token where the server will find it. Without a profile the default one is used.
+func login(ctx context.Context, profile string) error {
+	configs, defaultName := getBitbucketConfigs(false)
+	if profile == "" {
+		profile = defaultName
+	}
+	config, ok := configs[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", profile)
+	}
+	if config.OAuth == nil {
+		return fmt.Errorf("no OAuth client configured for %s: set BITBUCKET_OAUTH_CLIENT_ID or oauth.client_id in the profile", profile)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+
+	token, err := bitbucket.NewServer(config).OAuthLogin(ctx, func(authorizeURL string) {
+		// stdout stays clean; it is the MCP channel when the server runs over stdio
+		fmt.Fprintf(os.Stderr, "Open this URL in your browser to allow bbcli access to Bitbucket:\n\n  %s\n\nWaiting for the redirect to %s ...\n", authorizeURL, config.OAuth.RedirectURL)
+	})
+	if err != nil {
+		return err
+	}
+
+	expires := "does not expire"
+	if !token.Expiry.IsZero() {
+		expires = "expires " + token.Expiry.Local().Format(time.RFC1123)
+	}
+	fmt.Fprintf(os.Stderr, "Logged in. Token saved to %s (%s).\n", config.OAuth.TokenFile, expires)
+	return nil
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"bbcli/pkg/bitbucket"
 	"bbcli/pkg/tools"
@@ -11,66 +17,149 @@ import (
 )
 
 func main() {
-	mcpServer := NewMCPServer()
+	opts := parseServeOptions()
 
-	if err := server.ServeStdio(mcpServer); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if flag.Arg(0) == "login" {
+		if err := login(ctx, flag.Arg(1)); err != nil {
+			log.Fatalf("Login failed: %v", err)
+		}
+		return
+	}
+
+	mcpServer := NewMCPServer(ctx, opts.Transport)
+
+	if err := serve(ctx, mcpServer, opts); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
 
-func NewMCPServer() *server.MCPServer {
-	config := getBitbucketConfig()
-	bbClient := bitbucket.NewServer(config)
+// NewMCPServer creates the server with all tools, resources and prompts. Background work such as
+// polling subscribed pull requests stops when ctx is cancelled.
+func NewMCPServer(ctx context.Context, transport string) *server.MCPServer {
+	// Over HTTP each user can send their own token, so shared credentials are optional
+	configs, defaultInstance := getBitbucketConfigs(transport != "stdio")
+	instances := make(map[string]*bitbucket.Server, len(configs))
+	for name, config := range configs {
+		instances[name] = bitbucket.NewServer(config)
+	}
+	bbClient := instances[defaultInstance]
 
+	hooks := &server.Hooks{}
+	completions := tools.NewCompletionProvider(bbClient)
 	s := server.NewMCPServer(
 		"bbcli",
 		"1.0.0",
 		server.WithToolCapabilities(true),
+		server.WithResourceCapabilities(true, false),
+		server.WithPromptCapabilities(false),
+		server.WithCompletions(),
+		server.WithPromptCompletionProvider(completions),
+		server.WithResourceCompletionProvider(completions),
+		server.WithHooks(hooks),
 	)
 
 	registerBitbucketTools(s, bbClient)
+	if len(instances) > 1 {
+		tools.RegisterInstanceArgument(s, instances, defaultInstance)
+	}
+	registerBitbucketResources(s, bbClient)
+	registerBitbucketPrompts(s, bbClient)
+
+	watcher := tools.NewPullRequestWatcher(s, bbClient, getSubscriptionOptions())
+	watcher.Register(hooks)
+	go watcher.Run(ctx)
+
 	return s
 }
 
-func getBitbucketConfig() *bitbucket.Config {
-	config := &bitbucket.Config{
-		BaseURL:           os.Getenv("BITBUCKET_BASE_URL"),
-		Username:          os.Getenv("BITBUCKET_USERNAME"),
-		Password:          os.Getenv("BITBUCKET_PASSWORD"),
-		Token:             os.Getenv("BITBUCKET_TOKEN"),
-		DefaultProjectKey: os.Getenv("BITBUCKET_DEFAULT_PROJECT_KEY"),
+// getSubscriptionOptions reads BITBUCKET_POLL_INTERVAL, a duration such as 30s or a number of
+// seconds, and BITBUCKET_MAX_SUBSCRIPTIONS
+func getSubscriptionOptions() tools.SubscriptionOptions {
+	opts := tools.SubscriptionOptions{
+		PollInterval:     60 * time.Second,
+		MaxSubscriptions: 100,
 	}
 
-	var missing []string
-	if config.BaseURL == "" {
-		missing = append(missing, "BITBUCKET_BASE_URL")
+	if value := strings.TrimSpace(os.Getenv("BITBUCKET_POLL_INTERVAL")); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, convErr := strconv.Atoi(value)
+			if convErr != nil {
+				log.Fatalf("Invalid BITBUCKET_POLL_INTERVAL %q: use a duration such as 30s or a number of seconds", value)
+			}
+			interval = time.Duration(seconds) * time.Second
+		}
+		if interval < 5*time.Second {
+			log.Fatalf("Invalid BITBUCKET_POLL_INTERVAL %q: must be at least 5s", value)
+		}
+		opts.PollInterval = interval
 	}
 
-	if config.Token == "" && (config.Username == "" || config.Password == "") {
-		missing = append(missing, "BITBUCKET_TOKEN or both BITBUCKET_USERNAME and BITBUCKET_PASSWORD")
+	if value := strings.TrimSpace(os.Getenv("BITBUCKET_MAX_SUBSCRIPTIONS")); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			log.Fatalf("Invalid BITBUCKET_MAX_SUBSCRIPTIONS %q: must be a non-negative integer", value)
+		}
+		opts.MaxSubscriptions = limit
 	}
 
-	if len(missing) > 0 {
-		log.Fatalf("Missing required environment variables: %s", strings.Join(missing, ", "))
-	}
+	return opts
+}
 
-	return config
+// isTruthy interprets boolean environment variables such as BITBUCKET_READ_ONLY
+func isTruthy(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
 }
 
 func registerBitbucketTools(s *server.MCPServer, bb *bitbucket.Server) {
 	tools.RegisterListPullRequests(s, bb)
+	tools.RegisterMyPullRequests(s, bb)
+	tools.RegisterSearchPullRequests(s, bb)
 	tools.RegisterGetPullRequest(s, bb)
 	tools.RegisterGetPullRequestActivity(s, bb)
 	tools.RegisterCreatePullRequest(s, bb)
 	tools.RegisterApprovePullRequest(s, bb)
 	tools.RegisterUnapprovePullRequest(s, bb)
+	tools.RegisterSetReviewStatus(s, bb)
 	tools.RegisterMergePullRequest(s, bb)
 	tools.RegisterDeclinePullRequest(s, bb)
+	tools.RegisterReopenPullRequest(s, bb)
+	tools.RegisterDeletePullRequest(s, bb)
 	tools.RegisterGetPullRequestDiff(s, bb)
+	tools.RegisterListPullRequestComments(s, bb)
 	tools.RegisterCreatePullRequestComment(s, bb)
+	tools.RegisterSuggestChange(s, bb)
+	tools.RegisterSubmitReview(s, bb)
+	tools.RegisterUpdatePullRequestComment(s, bb)
+	tools.RegisterDeletePullRequestComment(s, bb)
+	tools.RegisterResolvePullRequestComment(s, bb)
+	tools.RegisterReopenPullRequestComment(s, bb)
+	tools.RegisterCreatePullRequestTask(s, bb)
+	tools.RegisterListPullRequestTasks(s, bb)
+	tools.RegisterResolvePullRequestTask(s, bb)
 
 	tools.RegisterGetRepos(s, bb)
 	tools.RegisterGetPullRequestSettings(s, bb)
 
 	tools.RegisterHelloWorld(s)
 }
+
+func registerBitbucketResources(s *server.MCPServer, bb *bitbucket.Server) {
+	tools.RegisterRepositoryResources(s, bb)
+	tools.RegisterPullRequestResources(s, bb)
+	tools.RegisterFileResources(s, bb)
+}
+
+func registerBitbucketPrompts(s *server.MCPServer, bb *bitbucket.Server) {
+	tools.RegisterReviewPullRequestPrompt(s, bb)
+	tools.RegisterSummarizeActivityPrompt(s, bb)
+	tools.RegisterDraftDescriptionPrompt(s, bb)
+	tools.RegisterMergeBlockersPrompt(s, bb)
+}
@@ -0,0 +1,275 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BranchModel is the repository's branching model used by MODEL_BRANCH and MODEL_CATEGORY matchers
+type BranchModel struct {
+	Development *BranchModelRef   `json:"development"`
+	Production  *BranchModelRef   `json:"production"`
+	Types       []BranchModelType `json:"types"`
+}
+
+type BranchModelRef struct {
+	ID        string `json:"id"`
+	DisplayID string `json:"displayId"`
+}
+
+type BranchModelType struct {
+	ID     string `json:"id"`
+	Prefix string `json:"prefix"`
+}
+
+// Branch is a branch of a repository as returned by the branch listing
+type Branch struct {
+	ID           string `json:"id"`
+	DisplayID    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+	IsDefault    bool   `json:"isDefault"`
+}
+
+// GetBranches returns up to limit branches of a repository, most recently modified first.
+// filter restricts them to branches whose name contains the text.
+func (bs *Server) GetBranches(projectKey, repoSlug, filter string, limit int) ([]Branch, error) {
+	var branches []Branch
+	start := 0
+
+	for len(branches) < limit {
+		params := url.Values{}
+		params.Set("orderBy", "MODIFICATION")
+		params.Set("start", strconv.Itoa(start))
+		params.Set("limit", strconv.Itoa(min(limit-len(branches), 100)))
+		if filter != "" {
+			params.Set("filterText", filter)
+		}
+		endpoint := fmt.Sprintf("/projects/%s/repos/%s/branches?%s", projectKey, repoSlug, params.Encode())
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, newAPIError(resp)
+		}
+
+		var page struct {
+			Values        []Branch `json:"values"`
+			IsLastPage    bool     `json:"isLastPage"`
+			NextPageStart int      `json:"nextPageStart"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, page.Values...)
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start = page.NextPageStart
+	}
+
+	return branches, nil
+}
+
+// DeleteBranch deletes a branch through the branch-utils API. endPoint is the commit the branch
+// is expected to point at, so a branch that moved in the meantime is not deleted. With dryRun
+// the server only checks that the deletion would be allowed.
+func (bs *Server) DeleteBranch(projectKey, repoSlug, branchRef, endPoint string, dryRun bool) error {
+	path := fmt.Sprintf("/rest/branch-utils/1.0/projects/%s/repos/%s/branches", projectKey, repoSlug)
+
+	deleteRequest := map[string]interface{}{
+		"name":   branchRef,
+		"dryRun": dryRun,
+	}
+	if endPoint != "" {
+		deleteRequest["endPoint"] = endPoint
+	}
+
+	jsonData, err := json.Marshal(deleteRequest)
+	if err != nil {
+		return err
+	}
+
+	resp, err := bs.makeRawRequest("DELETE", path, strings.NewReader(string(jsonData)), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
+
+// GetBranchRestrictions returns the branch permissions that apply to a repository
+func (bs *Server) GetBranchRestrictions(projectKey, repoSlug string) ([]BranchPermission, error) {
+	var allRestrictions []BranchPermission
+	start := 0
+
+	for {
+		path := fmt.Sprintf("/rest/branch-permissions/2.0/projects/%s/repos/%s/restrictions?start=%d&limit=100", projectKey, repoSlug, start)
+
+		resp, err := bs.makeRawRequest("GET", path, nil, "application/json")
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError(resp)
+			resp.Body.Close()
+			return nil, apiErr
+		}
+
+		var page struct {
+			Values     []BranchPermission `json:"values"`
+			IsLastPage bool               `json:"isLastPage"`
+			Limit      int                `json:"limit"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		allRestrictions = append(allRestrictions, page.Values...)
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start += page.Limit
+	}
+
+	return allRestrictions, nil
+}
+
+// GetBranchModel returns the repository's branching model, or nil when it has none
+func (bs *Server) GetBranchModel(projectKey, repoSlug string) (*BranchModel, error) {
+	path := fmt.Sprintf("/rest/branch-utils/1.0/projects/%s/repos/%s/branchmodel", projectKey, repoSlug)
+
+	resp, err := bs.makeRawRequest("GET", path, nil, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var model BranchModel
+	if err := json.NewDecoder(resp.Body).Decode(&model); err != nil {
+		return nil, err
+	}
+
+	return &model, nil
+}
+
+// IsBranchDeletionRestricted reports whether a read-only or no-deletes branch permission applies to
+// the branch. Patterns are matched generously, so a branch is rather considered protected than not.
+func (bs *Server) IsBranchDeletionRestricted(projectKey, repoSlug, branchRef string) (bool, error) {
+	restrictions, err := bs.GetBranchRestrictions(projectKey, repoSlug)
+	if err != nil {
+		return false, err
+	}
+
+	displayID := strings.TrimPrefix(branchRef, "refs/heads/")
+	var model *BranchModel
+	modelLoaded := false
+
+	for _, restriction := range restrictions {
+		if restriction.Type != "no-deletes" && restriction.Type != "read-only" {
+			continue
+		}
+
+		switch restriction.Matcher.Type.ID {
+		case "BRANCH":
+			if restriction.Matcher.ID == branchRef || restriction.Matcher.DisplayID == displayID {
+				return true, nil
+			}
+		case "PATTERN":
+			if matchBranchPattern(restriction.Matcher.ID, branchRef, displayID) {
+				return true, nil
+			}
+		case "MODEL_BRANCH", "MODEL_CATEGORY":
+			if !modelLoaded {
+				if model, err = bs.GetBranchModel(projectKey, repoSlug); err != nil {
+					return false, err
+				}
+				modelLoaded = true
+			}
+			if matchBranchModel(model, restriction.Matcher.Type.ID, restriction.Matcher.ID, branchRef, displayID) {
+				return true, nil
+			}
+		default:
+			// Unknown matcher types are treated as protecting every branch
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchBranchPattern matches a branch permission pattern where * matches any characters and ?
+// matches one, against both the full ref and the branch name
+func matchBranchPattern(pattern, branchRef, displayID string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return true
+	}
+	return compiled.MatchString(branchRef) || compiled.MatchString(displayID)
+}
+
+func matchBranchModel(model *BranchModel, matcherType, matcherID, branchRef, displayID string) bool {
+	if model == nil {
+		return false
+	}
+
+	if matcherType == "MODEL_BRANCH" {
+		var ref *BranchModelRef
+		switch strings.ToLower(matcherID) {
+		case "development":
+			ref = model.Development
+		case "production":
+			ref = model.Production
+		}
+		return ref != nil && (ref.ID == branchRef || ref.DisplayID == displayID)
+	}
+
+	for _, branchType := range model.Types {
+		if strings.EqualFold(branchType.ID, matcherID) && branchType.Prefix != "" && strings.HasPrefix(displayID, branchType.Prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,25 +1,59 @@
 package bitbucket
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // Server handles Bitbucket Server API operations
 type Server struct {
 	config *Config
 	client *http.Client
+
+	appPropsMu sync.Mutex
+	appProps   *ApplicationProperties
+
+	currentUserMu sync.Mutex
+	currentUser   *User
+
+	// Clients for tokens supplied per request, see ForContext
+	users userClients
+
+	// Set when config.Credentials and config.OAuth are
+	credentials *reloadingCredentials
+	oauth       *oauthTokenSource
+
+	// Set on clients that must not send any credentials, see ForContext
+	refused error
 }
 
 // NewServer creates a new Bitbucket Server API client
 func NewServer(config *Config) *Server {
-	return &Server{
+	bs := &Server{
 		config: config,
-		client: &http.Client{},
+		client: config.HTTPClient,
+	}
+	if bs.client == nil {
+		bs.client = &http.Client{}
+	}
+	if config.Credentials != nil {
+		bs.credentials = &reloadingCredentials{source: config.Credentials}
+	}
+	if config.OAuth != nil {
+		bs.oauth = &oauthTokenSource{
+			config: config.OAuth.withDefaults(config.BaseURL),
+			client: bs.client,
+		}
 	}
+	return bs
 }
 
 // GetDefaultProjectKey returns the default project key from config
@@ -27,60 +61,120 @@ func (bs *Server) GetDefaultProjectKey() string {
 	return bs.config.DefaultProjectKey
 }
 
+// IsReadOnly reports whether write requests are blocked by config
+func (bs *Server) IsReadOnly() bool {
+	return bs.config.ReadOnly
+}
+
 func (bs *Server) makeRequest(method, endpoint string, body io.Reader) (*http.Response, error) {
-	url := fmt.Sprintf("%s/rest/api/1.0%s", bs.config.BaseURL, endpoint)
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
+	return bs.makeRequestWithAccept(method, endpoint, body, "application/json")
+}
+
+func (bs *Server) makeRequestWithAccept(method, endpoint string, body io.Reader, accept string) (*http.Response, error) {
+	return bs.makeRawRequest(method, "/rest/api/1.0"+endpoint, body, accept)
+}
+
+// makeRawRequest sends a request to any Bitbucket REST API, e.g. /rest/branch-utils/1.0/...
+func (bs *Server) makeRawRequest(method, path string, body io.Reader, accept string) (*http.Response, error) {
+	// In read-only mode only GET requests reach the server
+	if bs.config.ReadOnly && method != "GET" {
+		return nil, fmt.Errorf("refusing %s %s: %w", method, path, ErrReadOnly)
 	}
 
-	// use Bearer token if provided, otherwise use basic auth
-	if bs.config.Token != "" {
-		req.Header.Set("Authorization", "Bearer "+bs.config.Token)
-	} else {
-		req.SetBasicAuth(bs.config.Username, bs.config.Password)
+	// Rejected credentials may be renewed and the request sent again, so keep the body
+	var payload []byte
+	if body != nil && bs.canRenewCredentials() {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	send := func() (*http.Response, string, error) {
+		reqBody := body
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		url := fmt.Sprintf("%s%s", bs.config.BaseURL, path)
+		req, err := http.NewRequest(method, url, reqBody)
+		if err != nil {
+			return nil, "", err
+		}
 
-	return bs.client.Do(req)
-}
+		if err := bs.authenticate(req); err != nil {
+			return nil, "", err
+		}
 
-func (bs *Server) GetPullRequests(projectKey, repoSlug string, state string, limit int) ([]PullRequest, error) {
-	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests", projectKey, repoSlug)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
 
-	params := []string{}
-	if state != "" {
-		params = append(params, fmt.Sprintf("state=%s", state))
-	}
-	if limit > 0 {
-		params = append(params, fmt.Sprintf("limit=%d", limit))
+		resp, err := bs.client.Do(req)
+		return resp, req.Header.Get("Authorization"), err
 	}
 
-	if len(params) > 0 {
-		endpoint += "?" + strings.Join(params, "&")
+	resp, authorization, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
 	}
 
-	resp, err := bs.makeRequest("GET", endpoint, nil)
+	// The token may have been rotated, or the OAuth access token expired early: renew the
+	// credentials and try once more
+	renewed, err := bs.renewCredentials(authorization)
 	if err != nil {
+		resp.Body.Close()
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	if !renewed {
+		return resp, nil
 	}
+	resp.Body.Close()
+	resp, _, err = send()
+	return resp, err
+}
 
-	var result struct {
-		Values []PullRequest `json:"values"`
-	}
+// GetPullRequests lists the pull requests of a repository. Server-side filters are passed as query
+// parameters; opts.Match is applied while paging so Limit counts matching pull requests only.
+func (bs *Server) GetPullRequests(projectKey, repoSlug string, opts PullRequestListOptions) ([]PullRequest, error) {
+	prs, _, err := bs.ScanPullRequests(projectKey, repoSlug, opts)
+	return prs, err
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+// ScanPullRequests works like GetPullRequests and also reports whether opts.MaxScan stopped the
+// scan before all pull requests of the repository were looked at
+func (bs *Server) ScanPullRequests(projectKey, repoSlug string, opts PullRequestListOptions) ([]PullRequest, bool, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests", projectKey, repoSlug)
+
+	params := url.Values{}
+	if opts.State != "" {
+		params.Set("state", opts.State)
+	}
+	if opts.Direction != "" {
+		params.Set("direction", opts.Direction)
+	}
+	if opts.At != "" {
+		params.Set("at", opts.At)
+	}
+	if opts.Order != "" {
+		params.Set("order", opts.Order)
+	}
+	if opts.WithAttributes != nil {
+		params.Set("withAttributes", strconv.FormatBool(*opts.WithAttributes))
+	}
+	if opts.WithProperties != nil {
+		params.Set("withProperties", strconv.FormatBool(*opts.WithProperties))
+	}
+	for i, participant := range opts.Participants {
+		n := i + 1
+		params.Set(fmt.Sprintf("username.%d", n), participant.Username)
+		if participant.Role != "" {
+			params.Set(fmt.Sprintf("role.%d", n), participant.Role)
+		}
+		if participant.Approved != nil {
+			params.Set(fmt.Sprintf("approved.%d", n), strconv.FormatBool(*participant.Approved))
+		}
 	}
 
-	return result.Values, nil
+	return bs.collectMatchingPullRequests(endpoint, params, opts.Limit, opts.MaxScan, opts.Match)
 }
 
 func (bs *Server) GetPullRequest(projectKey, repoSlug string, pullRequestID int) (*PullRequest, error) {
@@ -93,7 +187,7 @@ func (bs *Server) GetPullRequest(projectKey, repoSlug string, pullRequestID int)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return nil, newAPIError(resp)
 	}
 
 	var pr PullRequest
@@ -114,7 +208,7 @@ func (bs *Server) GetPullRequestActivity(projectKey, repoSlug string, pullReques
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return nil, newAPIError(resp)
 	}
 
 	var activity PullRequestActivity
@@ -125,6 +219,175 @@ func (bs *Server) GetPullRequestActivity(projectKey, repoSlug string, pullReques
 	return &activity, nil
 }
 
+// GetLatestPullRequestActivityID returns the ID of the newest activity of a pull request, 0 when
+// there is none. Activities are listed newest first, so one item is enough.
+func (bs *Server) GetLatestPullRequestActivityID(projectKey, repoSlug string, pullRequestID int) (int, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/activities?limit=1", projectKey, repoSlug, pullRequestID)
+
+	resp, err := bs.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(resp)
+	}
+
+	var activity PullRequestActivity
+	if err := json.NewDecoder(resp.Body).Decode(&activity); err != nil {
+		return 0, err
+	}
+	if len(activity.Values) == 0 {
+		return 0, nil
+	}
+
+	return activity.Values[0].ID, nil
+}
+
+// GetPullRequestActivitySince pages through the pull request activity, newest first, and returns
+// the activities created at or after since (epoch milliseconds). A zero since returns them all.
+func (bs *Server) GetPullRequestActivitySince(projectKey, repoSlug string, pullRequestID int, since int64) ([]Activity, error) {
+	var activities []Activity
+	start := 0
+
+	for {
+		endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/activities?start=%d&limit=100", projectKey, repoSlug, pullRequestID, start)
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, newAPIError(resp)
+		}
+
+		var page PullRequestActivity
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, activity := range page.Values {
+			if since > 0 && activity.CreatedDate < since {
+				return activities, nil
+			}
+			activities = append(activities, activity)
+		}
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start += page.Limit
+	}
+
+	return activities, nil
+}
+
+// GetPullRequestCommits returns up to limit commits of a pull request, newest first
+func (bs *Server) GetPullRequestCommits(projectKey, repoSlug string, pullRequestID int, limit int) ([]Commit, error) {
+	var commits []Commit
+	start := 0
+
+	for len(commits) < limit {
+		endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/commits?start=%d&limit=%d", projectKey, repoSlug, pullRequestID, start, min(limit-len(commits), 100))
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, newAPIError(resp)
+		}
+
+		var page CommitList
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, page.Values...)
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start += page.Limit
+	}
+
+	return commits, nil
+}
+
+// GetPullRequestCommentThreads pages through the pull request activity and returns the root comment
+// of every thread, with replies nested in Comments and the anchor set for inline comments
+func (bs *Server) GetPullRequestCommentThreads(projectKey, repoSlug string, pullRequestID int) ([]Comment, error) {
+	var roots []Comment
+	replyIDs := make(map[int]bool)
+	seen := make(map[int]bool)
+	start := 0
+
+	for {
+		endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/activities?start=%d&limit=100", projectKey, repoSlug, pullRequestID, start)
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, newAPIError(resp)
+		}
+
+		var page PullRequestActivity
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, activity := range page.Values {
+			if activity.Action != "COMMENTED" || activity.Comment == nil || seen[activity.Comment.ID] {
+				continue
+			}
+			comment := *activity.Comment
+			if comment.Anchor == nil {
+				comment.Anchor = activity.CommentAnchor
+			}
+			seen[comment.ID] = true
+			collectReplyIDs(comment.Comments, replyIDs)
+			roots = append(roots, comment)
+		}
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start += page.Limit
+	}
+
+	// Reply activities carry the reply itself, which is already nested under its root
+	threads := make([]Comment, 0, len(roots))
+	for _, comment := range roots {
+		if !replyIDs[comment.ID] {
+			threads = append(threads, comment)
+		}
+	}
+
+	return threads, nil
+}
+
+func collectReplyIDs(comments []Comment, ids map[int]bool) {
+	for _, comment := range comments {
+		ids[comment.ID] = true
+		collectReplyIDs(comment.Comments, ids)
+	}
+}
+
 func (bs *Server) CreatePullRequest(projectKey, repoSlug string, pr *PullRequest) (*PullRequest, error) {
 	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests", projectKey, repoSlug)
 
@@ -140,8 +403,7 @@ func (bs *Server) CreatePullRequest(projectKey, repoSlug string, pr *PullRequest
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
 	var createdPR PullRequest
@@ -152,38 +414,166 @@ func (bs *Server) CreatePullRequest(projectKey, repoSlug string, pr *PullRequest
 	return &createdPR, nil
 }
 
+// ApprovePullRequest approves the pull request as the current user
 func (bs *Server) ApprovePullRequest(projectKey, repoSlug string, pullRequestID int) error {
+	_, err := bs.SetReviewStatus(projectKey, repoSlug, pullRequestID, "APPROVED", "")
+	return err
+}
+
+// UnapprovalPullRequest removes the current user's approval or needs-work status
+func (bs *Server) UnapprovalPullRequest(projectKey, repoSlug string, pullRequestID int) error {
+	_, err := bs.SetReviewStatus(projectKey, repoSlug, pullRequestID, "UNAPPROVED", "")
+	return err
+}
+
+// SetReviewStatus sets the current user's status on a pull request to APPROVED, NEEDS_WORK or
+// UNAPPROVED. lastReviewedCommit is optional and records which commit the status applies to.
+// When Bitbucket does not tell who the current user is (ErrUnknownUser), approvals fall back to
+// the older approve endpoint, which needs no user slug but ignores lastReviewedCommit.
+func (bs *Server) SetReviewStatus(projectKey, repoSlug string, pullRequestID int, status, lastReviewedCommit string) (*Participant, error) {
+	user, err := bs.GetCurrentUser()
+	if err != nil {
+		if errors.Is(err, ErrUnknownUser) {
+			switch status {
+			case "APPROVED":
+				return bs.setApproval(projectKey, repoSlug, pullRequestID, "POST")
+			case "UNAPPROVED":
+				return bs.setApproval(projectKey, repoSlug, pullRequestID, "DELETE")
+			}
+		}
+		return nil, fmt.Errorf("failed to determine current user: %w", err)
+	}
+
+	return bs.SetParticipantStatus(projectKey, repoSlug, pullRequestID, user.Slug, status, lastReviewedCommit)
+}
+
+// setApproval approves (POST) or unapproves (DELETE) a pull request as the current user through
+// the approve endpoint
+func (bs *Server) setApproval(projectKey, repoSlug string, pullRequestID int, method string) (*Participant, error) {
 	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/approve", projectKey, repoSlug, pullRequestID)
 
-	resp, err := bs.makeRequest("POST", endpoint, nil)
+	resp, err := bs.makeRequest(method, endpoint, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
-	return nil
+	var participant Participant
+	if err := json.NewDecoder(resp.Body).Decode(&participant); err != nil {
+		return nil, err
+	}
+
+	return &participant, nil
 }
 
-func (bs *Server) UnapprovalPullRequest(projectKey, repoSlug string, pullRequestID int) error {
-	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/approve", projectKey, repoSlug, pullRequestID)
+// SetParticipantStatus updates a participant's status. Bitbucket only allows users to change their own status.
+func (bs *Server) SetParticipantStatus(projectKey, repoSlug string, pullRequestID int, userSlug, status, lastReviewedCommit string) (*Participant, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/participants/%s", projectKey, repoSlug, pullRequestID, url.PathEscape(userSlug))
 
-	resp, err := bs.makeRequest("DELETE", endpoint, nil)
+	participantRequest := map[string]interface{}{
+		"status": status,
+	}
+	if lastReviewedCommit != "" {
+		participantRequest["lastReviewedCommit"] = lastReviewedCommit
+	}
+
+	jsonData, err := json.Marshal(participantRequest)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	resp, err := bs.makeRequest("PUT", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
-	return nil
+	var participant Participant
+	if err := json.NewDecoder(resp.Body).Decode(&participant); err != nil {
+		return nil, err
+	}
+
+	return &participant, nil
+}
+
+// GetCurrentUser returns the authenticated user. Bitbucket has no "whoami" endpoint in the 1.0 API,
+// so the username is taken from the X-AUSERNAME response header and looked up to get the slug.
+func (bs *Server) GetCurrentUser() (*User, error) {
+	bs.currentUserMu.Lock()
+	defer bs.currentUserMu.Unlock()
+
+	if bs.currentUser != nil {
+		return bs.currentUser, nil
+	}
+
+	resp, err := bs.makeRequest("GET", "/application-properties", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newAPIError(resp)
+		resp.Body.Close()
+		return nil, apiErr
+	}
+	resp.Body.Close()
+
+	username := resp.Header.Get("X-AUSERNAME")
+	if username == "" {
+		username = bs.basicAuthUsername()
+	}
+	if username == "" {
+		return nil, fmt.Errorf("%w: the server did not report the authenticated user", ErrUnknownUser)
+	}
+
+	// The filter matches substrings of names, e-mail addresses and display names, so a short
+	// username may be far down the list
+	start := 0
+	for {
+		endpoint := fmt.Sprintf("/users?filter=%s&start=%d&limit=100", url.QueryEscape(username), start)
+		resp, err = bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError(resp)
+			resp.Body.Close()
+			return nil, apiErr
+		}
+
+		var page struct {
+			Values        []User `json:"values"`
+			IsLastPage    bool   `json:"isLastPage"`
+			NextPageStart int    `json:"nextPageStart"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range page.Values {
+			if strings.EqualFold(user.Name, username) {
+				bs.currentUser = &user
+				return bs.currentUser, nil
+			}
+		}
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start = page.NextPageStart
+	}
+
+	return nil, fmt.Errorf("%w: no user named %s", ErrUnknownUser, username)
 }
 
 func (bs *Server) MergePullRequest(projectKey, repoSlug string, pullRequestID int, version int) (*PullRequest, error) {
@@ -196,8 +586,7 @@ func (bs *Server) MergePullRequest(projectKey, repoSlug string, pullRequestID in
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
 	var mergedPR PullRequest
@@ -208,6 +597,29 @@ func (bs *Server) MergePullRequest(projectKey, repoSlug string, pullRequestID in
 	return &mergedPR, nil
 }
 
+// GetPullRequestMergeStatus tells whether a pull request can be merged and, if not, which merge
+// checks veto it
+func (bs *Server) GetPullRequestMergeStatus(projectKey, repoSlug string, pullRequestID int) (*MergeStatus, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/merge", projectKey, repoSlug, pullRequestID)
+
+	resp, err := bs.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var status MergeStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
 func (bs *Server) DeclinePullRequest(projectKey, repoSlug string, pullRequestID int, version int) (*PullRequest, error) {
 	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/decline?version=%d", projectKey, repoSlug, pullRequestID, version)
 
@@ -218,8 +630,7 @@ func (bs *Server) DeclinePullRequest(projectKey, repoSlug string, pullRequestID
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
 	var declinedPR PullRequest
@@ -230,37 +641,67 @@ func (bs *Server) DeclinePullRequest(projectKey, repoSlug string, pullRequestID
 	return &declinedPR, nil
 }
 
-func (bs *Server) GetPullRequestDiff(projectKey, repoSlug string, pullRequestID int, contextLines int, whitespace string, since string, until string) (string, error) {
-	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/diff", projectKey, repoSlug, pullRequestID)
+func (bs *Server) ReopenPullRequest(projectKey, repoSlug string, pullRequestID int, version int) (*PullRequest, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/reopen?version=%d", projectKey, repoSlug, pullRequestID, version)
 
-	// Add query parameters if provided
-	params := []string{}
-	if contextLines > 0 {
-		params = append(params, fmt.Sprintf("contextLines=%d", contextLines))
+	resp, err := bs.makeRequest("POST", endpoint, nil)
+	if err != nil {
+		return nil, err
 	}
-	if whitespace != "" {
-		params = append(params, fmt.Sprintf("whitespace=%s", whitespace))
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
 	}
-	if since != "" {
-		params = append(params, fmt.Sprintf("since=%s", since))
+
+	var reopenedPR PullRequest
+	if err := json.NewDecoder(resp.Body).Decode(&reopenedPR); err != nil {
+		return nil, err
 	}
-	if until != "" {
-		params = append(params, fmt.Sprintf("until=%s", until))
+
+	return &reopenedPR, nil
+}
+
+// DeletePullRequest permanently deletes a pull request. Requires repository admin permission.
+func (bs *Server) DeletePullRequest(projectKey, repoSlug string, pullRequestID int, version int) error {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d", projectKey, repoSlug, pullRequestID)
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"version": version,
+	})
+	if err != nil {
+		return err
 	}
 
-	if len(params) > 0 {
-		endpoint += "?" + strings.Join(params, "&")
+	resp, err := bs.makeRequest("DELETE", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	resp, err := bs.makeRequest("GET", endpoint, nil)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
+
+func (bs *Server) GetPullRequestDiff(projectKey, repoSlug string, pullRequestID int, contextLines int, whitespace string, since string, until string) (string, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/diff", projectKey, repoSlug, pullRequestID)
+
+	if params := diffQuery(contextLines, whitespace, since, until); len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	// Ask for text/plain, otherwise Bitbucket answers with the JSON diff model
+	resp, err := bs.makeRequestWithAccept("GET", endpoint, nil, "text/plain")
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return "", newAPIError(resp)
 	}
 
 	// Read the raw diff content as text
@@ -272,12 +713,66 @@ func (bs *Server) GetPullRequestDiff(projectKey, repoSlug string, pullRequestID
 	return string(diffBytes), nil
 }
 
-func (bs *Server) CreatePullRequestComment(projectKey, repoSlug string, pullRequestID int, text string, anchor *CommentAnchor) (*Comment, error) {
-	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments", projectKey, repoSlug, pullRequestID)
-
-	// Create the comment request body
-	commentRequest := map[string]interface{}{
-		"text": text,
+// diffQuery holds the optional parameters shared by the text and JSON forms of the diff
+func diffQuery(contextLines int, whitespace, since, until string) url.Values {
+	params := url.Values{}
+	if contextLines > 0 {
+		params.Set("contextLines", strconv.Itoa(contextLines))
+	}
+	if whitespace != "" {
+		params.Set("whitespace", whitespace)
+	}
+	if since != "" {
+		params.Set("since", since)
+	}
+	if until != "" {
+		params.Set("until", until)
+	}
+	return params
+}
+
+// GetPullRequestStructuredDiff returns the diff decoded into the typed JSON diff model
+func (bs *Server) GetPullRequestStructuredDiff(projectKey, repoSlug string, pullRequestID int, contextLines int, whitespace string, since string, until string) (*PullRequestDiff, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/diff", projectKey, repoSlug, pullRequestID)
+
+	if params := diffQuery(contextLines, whitespace, since, until); len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	resp, err := bs.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var diff PullRequestDiff
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		return nil, err
+	}
+
+	return &diff, nil
+}
+
+func (bs *Server) CreatePullRequestComment(projectKey, repoSlug string, pullRequestID int, text string, anchor *CommentAnchor, severity string) (*Comment, error) {
+	return bs.createPullRequestComment(projectKey, repoSlug, pullRequestID, newCommentRequest(text, anchor, severity))
+}
+
+// CreatePendingPullRequestComment adds a comment to the current user's pending review. It stays
+// invisible to others until the review is completed with CompletePullRequestReview.
+func (bs *Server) CreatePendingPullRequestComment(projectKey, repoSlug string, pullRequestID int, text string, anchor *CommentAnchor, severity string) (*Comment, error) {
+	commentRequest := newCommentRequest(text, anchor, severity)
+	commentRequest["state"] = "PENDING"
+	return bs.createPullRequestComment(projectKey, repoSlug, pullRequestID, commentRequest)
+}
+
+func newCommentRequest(text string, anchor *CommentAnchor, severity string) map[string]interface{} {
+	// Create the comment request body
+	commentRequest := map[string]interface{}{
+		"text": text,
 	}
 
 	// Add anchor if provided (for inline comments)
@@ -285,6 +780,17 @@ func (bs *Server) CreatePullRequestComment(projectKey, repoSlug string, pullRequ
 		commentRequest["anchor"] = anchor
 	}
 
+	// Severity is only understood by Bitbucket 7.x+, older servers ignore it
+	if severity != "" {
+		commentRequest["severity"] = severity
+	}
+
+	return commentRequest
+}
+
+func (bs *Server) createPullRequestComment(projectKey, repoSlug string, pullRequestID int, commentRequest map[string]interface{}) (*Comment, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments", projectKey, repoSlug, pullRequestID)
+
 	jsonData, err := json.Marshal(commentRequest)
 	if err != nil {
 		return nil, err
@@ -297,8 +803,7 @@ func (bs *Server) CreatePullRequestComment(projectKey, repoSlug string, pullRequ
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
 	var comment Comment
@@ -309,6 +814,468 @@ func (bs *Server) CreatePullRequestComment(projectKey, repoSlug string, pullRequ
 	return &comment, nil
 }
 
+func (bs *Server) GetPullRequestComment(projectKey, repoSlug string, pullRequestID int, commentID int) (*Comment, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments/%d", projectKey, repoSlug, pullRequestID, commentID)
+
+	resp, err := bs.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var comment Comment
+	if err := json.NewDecoder(resp.Body).Decode(&comment); err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+func (bs *Server) ReplyToPullRequestComment(projectKey, repoSlug string, pullRequestID int, parentID int, text string) (*Comment, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments", projectKey, repoSlug, pullRequestID)
+
+	// Replies inherit the anchor of the thread they belong to, so only the parent is sent
+	commentRequest := map[string]interface{}{
+		"text": text,
+		"parent": map[string]interface{}{
+			"id": parentID,
+		},
+	}
+
+	jsonData, err := json.Marshal(commentRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bs.makeRequest("POST", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError(resp)
+	}
+
+	var comment Comment
+	if err := json.NewDecoder(resp.Body).Decode(&comment); err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+func (bs *Server) UpdatePullRequestComment(projectKey, repoSlug string, pullRequestID int, commentID int, version int, text string) (*Comment, error) {
+	return bs.updatePullRequestComment(projectKey, repoSlug, pullRequestID, commentID, map[string]interface{}{
+		"text":    text,
+		"version": version,
+	})
+}
+
+// SetPullRequestCommentState resolves (RESOLVED) or reopens (OPEN) a comment thread. Requires Bitbucket 7.x+.
+func (bs *Server) SetPullRequestCommentState(projectKey, repoSlug string, pullRequestID int, commentID int, version int, state string) (*Comment, error) {
+	return bs.updatePullRequestComment(projectKey, repoSlug, pullRequestID, commentID, map[string]interface{}{
+		"state":   state,
+		"version": version,
+	})
+}
+
+func (bs *Server) updatePullRequestComment(projectKey, repoSlug string, pullRequestID int, commentID int, commentRequest map[string]interface{}) (*Comment, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments/%d", projectKey, repoSlug, pullRequestID, commentID)
+
+	jsonData, err := json.Marshal(commentRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bs.makeRequest("PUT", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var comment Comment
+	if err := json.NewDecoder(resp.Body).Decode(&comment); err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+func (bs *Server) DeletePullRequestComment(projectKey, repoSlug string, pullRequestID int, commentID int, version int) error {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments/%d?version=%d", projectKey, repoSlug, pullRequestID, commentID, version)
+
+	resp, err := bs.makeRequest("DELETE", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
+
+// SupportsPendingReviews reports whether the server has the review API for batching comments
+// into a pending review (Bitbucket Data Center 8.x+), and how many draft comments the current
+// user already has in their pending review of the pull request
+func (bs *Server) SupportsPendingReviews(projectKey, repoSlug string, pullRequestID int) (bool, int, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/review", projectKey, repoSlug, pullRequestID)
+
+	resp, err := bs.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return false, 0, nil
+	default:
+		return false, 0, newAPIError(resp)
+	}
+
+	var review struct {
+		Values []Comment `json:"values"`
+		Size   int       `json:"size"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return false, 0, err
+	}
+
+	return true, max(review.Size, len(review.Values)), nil
+}
+
+// CompletePullRequestReview publishes the current user's pending comments. The comment text is
+// posted as a general comment and participantStatus (APPROVED, NEEDS_WORK or UNAPPROVED) is optional.
+func (bs *Server) CompletePullRequestReview(projectKey, repoSlug string, pullRequestID int, commentText, participantStatus, lastReviewedCommit string) error {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/review", projectKey, repoSlug, pullRequestID)
+
+	reviewRequest := map[string]interface{}{}
+	if commentText != "" {
+		reviewRequest["commentText"] = commentText
+	}
+	if participantStatus != "" {
+		reviewRequest["participantStatus"] = participantStatus
+	}
+	if lastReviewedCommit != "" {
+		reviewRequest["lastReviewedCommit"] = lastReviewedCommit
+	}
+
+	jsonData, err := json.Marshal(reviewRequest)
+	if err != nil {
+		return err
+	}
+
+	resp, err := bs.makeRequest("PUT", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
+
+// GetApplicationProperties returns the server version information. The result is cached
+// since the version of a running server does not change between calls.
+func (bs *Server) GetApplicationProperties() (*ApplicationProperties, error) {
+	bs.appPropsMu.Lock()
+	defer bs.appPropsMu.Unlock()
+
+	if bs.appProps != nil {
+		return bs.appProps, nil
+	}
+
+	resp, err := bs.makeRequest("GET", "/application-properties", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var props ApplicationProperties
+	if err := json.NewDecoder(resp.Body).Decode(&props); err != nil {
+		return nil, err
+	}
+
+	bs.appProps = &props
+	return bs.appProps, nil
+}
+
+// SupportsBlockerComments reports whether the server uses the Bitbucket 7.x+ blocker comment
+// model for tasks instead of the legacy /tasks API
+func (bs *Server) SupportsBlockerComments() (bool, error) {
+	major, err := bs.majorVersion()
+	if err != nil {
+		return false, err
+	}
+	return major >= 7, nil
+}
+
+// SupportsMultilineComments reports whether inline comments can span several lines with a
+// MultilineMarker, which Bitbucket 8.0+ supports
+func (bs *Server) SupportsMultilineComments() (bool, error) {
+	major, err := bs.majorVersion()
+	if err != nil {
+		return false, err
+	}
+	return major >= 8, nil
+}
+
+// majorVersion returns the major version of the Bitbucket server
+func (bs *Server) majorVersion() (int, error) {
+	props, err := bs.GetApplicationProperties()
+	if err != nil {
+		return 0, err
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(props.Version, ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized server version %q", props.Version)
+	}
+	return major, nil
+}
+
+// CreatePullRequestBlockerComment creates a task using the Bitbucket 7.x+ blocker comment model.
+// When parentID is set the task is added to that comment's thread.
+func (bs *Server) CreatePullRequestBlockerComment(projectKey, repoSlug string, pullRequestID int, text string, parentID int) (*Comment, error) {
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/comments", projectKey, repoSlug, pullRequestID)
+
+	commentRequest := map[string]interface{}{
+		"text":     text,
+		"severity": "BLOCKER",
+	}
+	if parentID > 0 {
+		commentRequest["parent"] = map[string]interface{}{
+			"id": parentID,
+		}
+	}
+
+	jsonData, err := json.Marshal(commentRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bs.makeRequest("POST", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError(resp)
+	}
+
+	var comment Comment
+	if err := json.NewDecoder(resp.Body).Decode(&comment); err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// GetPullRequestBlockerComments lists blocker comments (Bitbucket 7.x+). An empty state returns all of them.
+func (bs *Server) GetPullRequestBlockerComments(projectKey, repoSlug string, pullRequestID int, state string) ([]Comment, error) {
+	var allComments []Comment
+	start := 0
+
+	for {
+		endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/blocker-comments?start=%d&limit=100", projectKey, repoSlug, pullRequestID, start)
+		if state != "" {
+			endpoint += "&state=" + state
+		}
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError(resp)
+			resp.Body.Close()
+			return nil, apiErr
+		}
+
+		var page struct {
+			Values     []Comment `json:"values"`
+			IsLastPage bool      `json:"isLastPage"`
+			Limit      int       `json:"limit"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		allComments = append(allComments, page.Values...)
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start += page.Limit
+	}
+
+	return allComments, nil
+}
+
+// CreateTask creates a task on a comment using the legacy /tasks API (Bitbucket 6.x and earlier)
+func (bs *Server) CreateTask(commentID int, text string) (*Task, error) {
+	taskRequest := map[string]interface{}{
+		"text": text,
+		"anchor": map[string]interface{}{
+			"id":   commentID,
+			"type": "COMMENT",
+		},
+	}
+
+	jsonData, err := json.Marshal(taskRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bs.makeRequest("POST", "/tasks", strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var task Task
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+// GetPullRequestTasks lists tasks using the legacy /tasks API (Bitbucket 6.x and earlier)
+func (bs *Server) GetPullRequestTasks(projectKey, repoSlug string, pullRequestID int) ([]Task, error) {
+	var allTasks []Task
+	start := 0
+
+	for {
+		endpoint := fmt.Sprintf("/projects/%s/repos/%s/pull-requests/%d/tasks?start=%d&limit=100", projectKey, repoSlug, pullRequestID, start)
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError(resp)
+			resp.Body.Close()
+			return nil, apiErr
+		}
+
+		var page TaskList
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		allTasks = append(allTasks, page.Values...)
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start += page.Limit
+	}
+
+	return allTasks, nil
+}
+
+// UpdateTaskState sets the state (OPEN or RESOLVED) of a task using the legacy /tasks API
+func (bs *Server) UpdateTaskState(taskID int, state string) (*Task, error) {
+	endpoint := fmt.Sprintf("/tasks/%d", taskID)
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"id":    taskID,
+		"state": state,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bs.makeRequest("PUT", endpoint, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var task Task
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+// GetProjects returns up to limit projects visible to the user
+func (bs *Server) GetProjects(limit int) ([]Project, error) {
+	var projects []Project
+	start := 0
+
+	for len(projects) < limit {
+		endpoint := fmt.Sprintf("/projects?start=%d&limit=%d", start, min(limit-len(projects), 100))
+
+		resp, err := bs.makeRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, newAPIError(resp)
+		}
+
+		var page struct {
+			Values        []Project `json:"values"`
+			IsLastPage    bool      `json:"isLastPage"`
+			NextPageStart int       `json:"nextPageStart"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, page.Values...)
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start = page.NextPageStart
+	}
+
+	return projects, nil
+}
+
 type RepositoryResponse struct {
 	Size       int          `json:"size"`
 	Limit      int          `json:"limit"`
@@ -330,7 +1297,7 @@ func (bs *Server) GetRepos(projectKey string, limit, start int) ([]Repository, e
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+			return nil, newAPIError(resp)
 		}
 
 		var pageResponse RepositoryResponse
@@ -359,7 +1326,7 @@ func (bs *Server) GetPullRequestSettings(projectKey, repoSlug string) (*PullRequ
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return nil, newAPIError(resp)
 	}
 
 	var settings PullRequestSettings
@@ -0,0 +1,382 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCredentials is returned when neither the configuration nor the request supplies credentials
+var ErrNoCredentials = errors.New("no Bitbucket credentials")
+
+// ErrCredentialSource is returned when the configured credential source cannot be read
+var ErrCredentialSource = errors.New("cannot load Bitbucket credentials")
+
+// credentialCommandTimeout bounds how long a credential helper may take, e.g. to unlock a vault
+const credentialCommandTimeout = 30 * time.Second
+
+// userClientIdleTTL is how long a client for a user's token is kept after its last use
+const userClientIdleTTL = time.Hour
+
+// ErrTokenNotForInstance is returned when a request carries users' tokens, but none for the
+// Bitbucket instance a call targets. Tokens are never sent to an instance they were not given for.
+var ErrTokenNotForInstance = errors.New("no Bitbucket token was sent for this instance")
+
+// ErrUserTokenRequired is returned when a request carries no user's token and the server is
+// configured not to fall back to the shared credentials
+var ErrUserTokenRequired = errors.New("a Bitbucket token of the user is required")
+
+type tokenContextKey struct{}
+
+type requireTokenContextKey struct{}
+
+type instanceContextKey struct{}
+
+// userTokens are the tokens a request carries: one for the default instance and any number
+// scoped to a profile, keyed by the lower-cased profile name
+type userTokens struct {
+	unscoped string
+	scoped   map[string]string
+}
+
+func tokensFromContext(ctx context.Context) userTokens {
+	tokens, _ := ctx.Value(tokenContextKey{}).(userTokens)
+	return tokens
+}
+
+// ContextWithToken returns a context that makes ForContext act as the owner of token, a
+// Bitbucket personal access token, on the instance ForContext is called on. It is not used for
+// instances chosen with ContextWithInstance. An empty token leaves ctx unchanged.
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	tokens := tokensFromContext(ctx)
+	tokens.unscoped = token
+	return context.WithValue(ctx, tokenContextKey{}, tokens)
+}
+
+// ContextWithProfileToken returns a context that makes ForContext act as the owner of token on
+// the instance configured by the named profile only, whether it is chosen with
+// ContextWithInstance or not. Profile names are matched case-insensitively. An empty token leaves
+// ctx unchanged.
+func ContextWithProfileToken(ctx context.Context, profile, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	tokens := tokensFromContext(ctx)
+	scoped := make(map[string]string, len(tokens.scoped)+1)
+	for name, t := range tokens.scoped {
+		scoped[name] = t
+	}
+	scoped[strings.ToLower(profile)] = token
+	tokens.scoped = scoped
+	return context.WithValue(ctx, tokenContextKey{}, tokens)
+}
+
+// ContextRequiringToken returns a context that makes ForContext refuse calls that carry no user's
+// token with ErrUserTokenRequired, instead of making them with the shared credentials
+func ContextRequiringToken(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requireTokenContextKey{}, true)
+}
+
+// ContextWithInstance returns a context that makes ForContext use instance, the client of
+// another Bitbucket instance, in place of the client it is called on. Only a token scoped to the
+// instance's profile is sent to it.
+func ContextWithInstance(ctx context.Context, instance *Server) context.Context {
+	return context.WithValue(ctx, instanceContextKey{}, instance)
+}
+
+// userClients holds the clients created for users' own tokens, keyed by the token's hash
+type userClients struct {
+	mu      sync.Mutex
+	clients map[[sha256.Size]byte]*userClient
+}
+
+type userClient struct {
+	server   *Server
+	lastUsed time.Time
+}
+
+// ForContext returns the client to use for a request. When ctx carries a user's token for the
+// instance (see ContextWithToken and ContextWithProfileToken) it is a client authenticated with
+// that token, so Bitbucket attributes changes to the user and enforces their permissions;
+// otherwise it is bs itself. Clients are reused across requests with the same token so per-user
+// caches such as the current user stay warm, and dropped after an hour without use. A client
+// chosen with ContextWithInstance takes the place of bs.
+//
+// When ctx carries tokens but none for the instance, the returned client fails every request
+// with ErrTokenNotForInstance rather than fall back to the shared credentials. When it carries
+// none and was made with ContextRequiringToken, it fails with ErrUserTokenRequired.
+func (bs *Server) ForContext(ctx context.Context) *Server {
+	target, routed := bs, false
+	if instance, ok := ctx.Value(instanceContextKey{}).(*Server); ok && instance != bs {
+		target, routed = instance, true
+	}
+
+	tokens := tokensFromContext(ctx)
+	token := tokens.scoped[strings.ToLower(target.config.Profile)]
+	if token == "" && !routed {
+		token = tokens.unscoped
+	}
+	if token == "" {
+		if tokens.unscoped != "" || len(tokens.scoped) > 0 {
+			return target.refusing(fmt.Errorf("%w (profile %q)", ErrTokenNotForInstance, target.config.Profile))
+		}
+		if required, _ := ctx.Value(requireTokenContextKey{}).(bool); required {
+			return target.refusing(ErrUserTokenRequired)
+		}
+		return target
+	}
+	return target.forToken(token)
+}
+
+// forToken returns the client authenticated with a user's token
+func (bs *Server) forToken(token string) *Server {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	bs.users.mu.Lock()
+	defer bs.users.mu.Unlock()
+
+	if bs.users.clients == nil {
+		bs.users.clients = make(map[[sha256.Size]byte]*userClient)
+	}
+	for k, client := range bs.users.clients {
+		if now.Sub(client.lastUsed) > userClientIdleTTL {
+			delete(bs.users.clients, k)
+		}
+	}
+
+	client, ok := bs.users.clients[key]
+	if !ok {
+		config := *bs.config
+		config.Token = token
+		config.Username = ""
+		config.Password = ""
+		config.OAuth = nil
+		config.Credentials = nil
+		client = &userClient{server: &Server{config: &config, client: bs.client}}
+		bs.users.clients[key] = client
+	}
+	client.lastUsed = now
+	return client.server
+}
+
+// refusing returns a client for the same instance that fails every request with err
+func (bs *Server) refusing(err error) *Server {
+	config := *bs.config
+	config.Token = ""
+	config.Username = ""
+	config.Password = ""
+	config.OAuth = nil
+	config.Credentials = nil
+	return &Server{config: &config, client: bs.client, refused: err}
+}
+
+// Credentials are either a token or a username and password
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// authorization returns the Authorization header value for the credentials
+func (c Credentials) authorization() string {
+	if c.Token != "" {
+		return "Bearer " + c.Token
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+}
+
+// CredentialSource supplies credentials kept outside the environment. They are loaded on first
+// use and loaded again when Bitbucket rejects them, so rotated tokens are picked up without a
+// restart.
+type CredentialSource interface {
+	Load() (Credentials, error)
+	String() string // Describes the source in error messages, without revealing secrets
+}
+
+// TokenFile reads a token from a file, e.g. a mounted secret
+type TokenFile string
+
+func (f TokenFile) Load() (Credentials, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return Credentials{}, err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return Credentials{}, fmt.Errorf("the file is empty")
+	}
+	return Credentials{Token: token}, nil
+}
+
+func (f TokenFile) String() string {
+	return "token file " + string(f)
+}
+
+// TokenCommand runs a shell command, such as a password manager CLI, that prints a token
+type TokenCommand string
+
+func (c TokenCommand) Load() (Credentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", string(c))
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", string(c))
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return Credentials{}, fmt.Errorf("%w: %s", err, message)
+		}
+		return Credentials{}, err
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return Credentials{}, fmt.Errorf("the command printed nothing")
+	}
+	return Credentials{Token: token}, nil
+}
+
+func (c TokenCommand) String() string {
+	return "token command"
+}
+
+// reloadingCredentials caches the credentials of a CredentialSource between reloads
+type reloadingCredentials struct {
+	source CredentialSource
+
+	mu      sync.Mutex
+	current *Credentials
+}
+
+func (rc *reloadingCredentials) get() (Credentials, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.current == nil {
+		if err := rc.loadLocked(); err != nil {
+			return Credentials{}, err
+		}
+	}
+	return *rc.current, nil
+}
+
+// reload loads the credentials again after Bitbucket rejected the sent Authorization header, and
+// reports whether they changed. Credentials already reloaded by a concurrent request count as
+// changed.
+func (rc *reloadingCredentials) reload(sent string) (bool, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.current != nil && rc.current.authorization() != sent {
+		return true, nil
+	}
+	if err := rc.loadLocked(); err != nil {
+		return false, err
+	}
+	return rc.current.authorization() != sent, nil
+}
+
+func (rc *reloadingCredentials) loadLocked() error {
+	creds, err := rc.source.Load()
+	if err != nil {
+		return fmt.Errorf("%w from %s: %v", ErrCredentialSource, rc.source, err)
+	}
+	rc.current = &creds
+	return nil
+}
+
+// HasCredentials reports whether the configuration holds a token, a username and password, a
+// credential source or OAuth settings
+func (bs *Server) HasCredentials() bool {
+	return bs.config.Token != "" || bs.credentials != nil || bs.oauth != nil ||
+		(bs.config.Username != "" && bs.config.Password != "")
+}
+
+// basicAuthUsername returns the username of basic auth credentials, from the configuration or a
+// credential source such as netrc. Tokens carry no username, so it is empty for them.
+func (bs *Server) basicAuthUsername() string {
+	switch {
+	case bs.config.Token != "":
+		return ""
+	case bs.credentials != nil:
+		creds, err := bs.credentials.get()
+		if err != nil || creds.Token != "" {
+			return ""
+		}
+		return creds.Username
+	case bs.oauth != nil:
+		return ""
+	default:
+		return bs.config.Username
+	}
+}
+
+// canRenewCredentials reports whether rejected credentials can be replaced, by loading them
+// again from their source or refreshing the OAuth token
+func (bs *Server) canRenewCredentials() bool {
+	return bs.config.Token == "" && (bs.credentials != nil || bs.oauth != nil)
+}
+
+// renewCredentials replaces credentials Bitbucket rejected, given the Authorization header that
+// was sent, and reports whether the request is worth sending again
+func (bs *Server) renewCredentials(sent string) (bool, error) {
+	switch {
+	case !bs.canRenewCredentials():
+		return false, nil
+	case bs.credentials != nil:
+		return bs.credentials.reload(sent)
+	default:
+		if err := bs.oauth.refresh(strings.TrimPrefix(sent, "Bearer ")); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// authenticate adds the configured credentials to a request. A static token is preferred over
+// a credential source, which is preferred over OAuth and then basic auth.
+func (bs *Server) authenticate(req *http.Request) error {
+	switch {
+	case bs.refused != nil:
+		return bs.refused
+	case bs.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+bs.config.Token)
+	case bs.credentials != nil:
+		creds, err := bs.credentials.get()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", creds.authorization())
+	case bs.oauth != nil:
+		token, err := bs.oauth.accessToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case bs.config.Username != "" && bs.config.Password != "":
+		req.SetBasicAuth(bs.config.Username, bs.config.Password)
+	default:
+		return ErrNoCredentials
+	}
+	return nil
+}
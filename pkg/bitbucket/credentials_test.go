@@ -0,0 +1,179 @@
+package bitbucket
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNetrcCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		netrc   string
+		host    string
+		want    Credentials
+		wantErr bool
+	}{
+		{
+			name:  "login and password",
+			netrc: "machine git.example.com login other password nope\nmachine bitbucket.example.com\n  login alice\n  password secret\n",
+			host:  "bitbucket.example.com",
+			want:  Credentials{Username: "alice", Password: "secret"},
+		},
+		{
+			name:  "host matched case-insensitively",
+			netrc: "machine Bitbucket.Example.com login alice password secret",
+			host:  "bitbucket.example.com",
+			want:  Credentials{Username: "alice", Password: "secret"},
+		},
+		{
+			name:  "password alone is a token",
+			netrc: "machine bitbucket.example.com password the-token",
+			host:  "bitbucket.example.com",
+			want:  Credentials{Token: "the-token"},
+		},
+		{
+			name:  "default entry as fallback",
+			netrc: "machine git.example.com login other password nope\ndefault login bob password fallback\n",
+			host:  "bitbucket.example.com",
+			want:  Credentials{Username: "bob", Password: "fallback"},
+		},
+		{
+			name:  "machine entry wins over an earlier default",
+			netrc: "default login bob password fallback\nmachine bitbucket.example.com login alice password secret\n",
+			host:  "bitbucket.example.com",
+			want:  Credentials{Username: "alice", Password: "secret"},
+		},
+		{
+			name:  "comments and macros are skipped",
+			netrc: "# machine bitbucket.example.com password commented\nmacdef init\nmachine bitbucket.example.com password in-macro\n\nmachine bitbucket.example.com account team login alice password secret\n",
+			host:  "bitbucket.example.com",
+			want:  Credentials{Username: "alice", Password: "secret"},
+		},
+		{
+			name:    "no entry for the host",
+			netrc:   "machine git.example.com login other password nope",
+			host:    "bitbucket.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "entry without a password",
+			netrc:   "machine bitbucket.example.com login alice",
+			host:    "bitbucket.example.com",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "netrc")
+			if err := os.WriteFile(path, []byte(tt.netrc), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			creds, err := NetrcCredentials{Path: path, Host: tt.host}.Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Load = %+v, want an error", creds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if creds != tt.want {
+				t.Errorf("Load = %+v, want %+v", creds, tt.want)
+			}
+		})
+	}
+}
+
+// countingSource returns the current token and counts how often it was loaded
+type countingSource struct {
+	mu    sync.Mutex
+	token string
+	loads int
+}
+
+func (s *countingSource) Load() (Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	return Credentials{Token: s.token}, nil
+}
+
+func (s *countingSource) String() string {
+	return "test source"
+}
+
+func (s *countingSource) rotate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+func TestReloadingCredentialsOnUnauthorized(t *testing.T) {
+	var mu sync.Mutex
+	accepted := "old"
+	var bodies []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") != "Bearer "+accepted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer api.Close()
+
+	source := &countingSource{token: "old"}
+	bs := NewServer(&Config{BaseURL: api.URL, Credentials: source})
+	post := func() int {
+		t.Helper()
+		resp, err := bs.makeRawRequest("POST", "/rest/api/1.0/test", strings.NewReader(`{"n":1}`), "application/json")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(); status != http.StatusOK || source.loads != 1 {
+		t.Fatalf("first request: status %d after %d loads, want 200 after 1", status, source.loads)
+	}
+	if status := post(); status != http.StatusOK || source.loads != 1 {
+		t.Fatalf("second request: status %d after %d loads, want the cached token", status, source.loads)
+	}
+
+	// The token is rotated: the cached one is rejected once, then the new one is loaded and sent
+	mu.Lock()
+	accepted = "new"
+	bodies = nil
+	mu.Unlock()
+	source.rotate("new")
+	if status := post(); status != http.StatusOK || source.loads != 2 {
+		t.Fatalf("after rotation: status %d after %d loads, want 200 after 2", status, source.loads)
+	}
+	if len(bodies) != 2 || bodies[0] != `{"n":1}` || bodies[1] != `{"n":1}` {
+		t.Errorf("bodies sent = %q, want the rejected request and the same body retried", bodies)
+	}
+
+	// A revoked token that the source still returns is not retried again
+	mu.Lock()
+	accepted = "newer"
+	bodies = nil
+	mu.Unlock()
+	if status := post(); status != http.StatusUnauthorized || source.loads != 3 {
+		t.Fatalf("revoked token: status %d after %d loads, want 401 after 3", status, source.loads)
+	}
+	if len(bodies) != 1 {
+		t.Errorf("Bitbucket got %d requests, want no retry with unchanged credentials", len(bodies))
+	}
+}
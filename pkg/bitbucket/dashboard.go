@@ -0,0 +1,164 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DashboardOptions filters the pull requests on the current user's dashboard
+type DashboardOptions struct {
+	State             string   // OPEN, MERGED, DECLINED or ALL (any state)
+	Role              string   // AUTHOR, REVIEWER or PARTICIPANT
+	ParticipantStatus []string // UNAPPROVED, NEEDS_WORK and/or APPROVED
+	Order             string   // NEWEST or OLDEST
+	Limit             int
+}
+
+// BuildStats summarizes the build results reported for a commit
+type BuildStats struct {
+	Successful int `json:"successful"`
+	InProgress int `json:"inProgress"`
+	Failed     int `json:"failed"`
+	Cancelled  int `json:"cancelled,omitempty"`
+	Unknown    int `json:"unknown,omitempty"`
+}
+
+// State condenses the build stats into FAILED, INPROGRESS, SUCCESSFUL or NONE
+func (bs *BuildStats) State() string {
+	switch {
+	case bs == nil:
+		return "NONE"
+	case bs.Failed > 0:
+		return "FAILED"
+	case bs.InProgress > 0:
+		return "INPROGRESS"
+	case bs.Successful > 0:
+		return "SUCCESSFUL"
+	default:
+		return "NONE"
+	}
+}
+
+// GetDashboardPullRequests returns pull requests across all repositories in which the current user
+// is involved as author, reviewer or participant
+func (bs *Server) GetDashboardPullRequests(opts DashboardOptions) ([]PullRequest, error) {
+	params := url.Values{}
+	// The endpoint has no ALL state; leaving the parameter out returns every state
+	if opts.State != "" && opts.State != "ALL" {
+		params.Set("state", opts.State)
+	}
+	if opts.Role != "" {
+		params.Set("role", opts.Role)
+	}
+	if len(opts.ParticipantStatus) > 0 {
+		params.Set("participantStatus", strings.Join(opts.ParticipantStatus, ","))
+	}
+	if opts.Order != "" {
+		params.Set("order", opts.Order)
+	}
+
+	return bs.collectPullRequests("/dashboard/pull-requests", params, opts.Limit)
+}
+
+// GetInboxPullRequests returns the open pull requests waiting for the current user's review
+func (bs *Server) GetInboxPullRequests(limit int) ([]PullRequest, error) {
+	params := url.Values{}
+	params.Set("role", "REVIEWER")
+
+	return bs.collectPullRequests("/inbox/pull-requests", params, limit)
+}
+
+// collectPullRequests pages through a pull request listing until limit results are collected,
+// or all of them when limit is 0
+func (bs *Server) collectPullRequests(endpoint string, params url.Values, limit int) ([]PullRequest, error) {
+	prs, _, err := bs.collectMatchingPullRequests(endpoint, params, limit, 0, nil)
+	return prs, err
+}
+
+// collectMatchingPullRequests pages through a pull request listing, keeping those accepted by match
+// (all of them when match is nil) until limit are found or maxScan pull requests have been looked at.
+// It reports whether maxScan ended the scan before the listing did.
+func (bs *Server) collectMatchingPullRequests(endpoint string, params url.Values, limit, maxScan int, match func(PullRequest) bool) ([]PullRequest, bool, error) {
+	var matched []PullRequest
+	start, scanned := 0, 0
+
+	for {
+		// Without a client-side filter there is no point fetching more than still fits the limit
+		pageSize := 100
+		if match == nil && limit > 0 && limit-len(matched) < pageSize {
+			pageSize = limit - len(matched)
+		}
+		params.Set("start", fmt.Sprintf("%d", start))
+		params.Set("limit", fmt.Sprintf("%d", pageSize))
+
+		resp, err := bs.makeRequest("GET", endpoint+"?"+params.Encode(), nil)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError(resp)
+			resp.Body.Close()
+			return nil, false, apiErr
+		}
+
+		var page struct {
+			Values        []PullRequest `json:"values"`
+			IsLastPage    bool          `json:"isLastPage"`
+			NextPageStart int           `json:"nextPageStart"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, err
+		}
+
+		for i, pr := range page.Values {
+			scanned++
+			if match == nil || match(pr) {
+				matched = append(matched, pr)
+				if limit > 0 && len(matched) >= limit {
+					return matched, false, nil
+				}
+			}
+			if maxScan > 0 && scanned >= maxScan {
+				return matched, i < len(page.Values)-1 || !page.IsLastPage, nil
+			}
+		}
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			break
+		}
+		start = page.NextPageStart
+	}
+
+	return matched, false, nil
+}
+
+// GetCommitBuildStats returns the build result counts for a commit, or nil when no builds are reported
+func (bs *Server) GetCommitBuildStats(commitID string) (*BuildStats, error) {
+	path := fmt.Sprintf("/rest/build-status/1.0/commits/stats/%s", commitID)
+
+	resp, err := bs.makeRawRequest("GET", path, nil, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var stats BuildStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,230 @@
+package bitbucket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Path returns the path of the file after the change, or before it for deleted files
+func (d *Diff) Path() string {
+	if d.Destination != nil {
+		return d.Destination.ToString
+	}
+	if d.Source != nil {
+		return d.Source.ToString
+	}
+	return ""
+}
+
+// SourcePath returns the path of the file before the change, empty for added files
+func (d *Diff) SourcePath() string {
+	if d.Source != nil {
+		return d.Source.ToString
+	}
+	return ""
+}
+
+// FindFile returns the diff of the file with the given path, matching either side of a rename
+func (pd *PullRequestDiff) FindFile(path string) *Diff {
+	for i := range pd.Diffs {
+		if pd.Diffs[i].Path() == path || pd.Diffs[i].SourcePath() == path {
+			return &pd.Diffs[i]
+		}
+	}
+	return nil
+}
+
+// AnchorFor returns the inline comment anchor for a line of a file in the diff. With fileType TO
+// the line is numbered in the new version of the file (ADDED or CONTEXT lines), with FROM in the
+// old version (REMOVED or CONTEXT lines).
+func (pd *PullRequestDiff) AnchorFor(path string, line int, fileType string) (*CommentAnchor, error) {
+	if fileType != "TO" && fileType != "FROM" {
+		return nil, fmt.Errorf("invalid file type %q: must be TO or FROM", fileType)
+	}
+
+	diff, err := pd.commentableFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hunk := range diff.Hunks {
+		for _, segment := range hunk.Segments {
+			if fileType == "TO" && segment.Type == "REMOVED" || fileType == "FROM" && segment.Type == "ADDED" {
+				continue
+			}
+			for _, l := range segment.Lines {
+				lineNumber := l.Destination
+				if fileType == "FROM" {
+					lineNumber = l.Source
+				}
+				if lineNumber != line {
+					continue
+				}
+
+				anchor := &CommentAnchor{
+					Line:     line,
+					LineType: segment.Type,
+					FileType: fileType,
+					Path:     diff.Path(),
+				}
+				if srcPath := diff.SourcePath(); srcPath != "" && srcPath != anchor.Path {
+					anchor.SrcPath = srcPath
+				}
+				return anchor, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("line %d (%s) of %s is not part of the diff; lines in the diff: %s",
+		line, fileType, path, describeHunkRanges(diff, fileType))
+}
+
+// AnchorForRange returns the anchor of a multi-line comment on lines startLine to endLine of the
+// new version of a file. The lines must be ADDED or CONTEXT lines of a single hunk. The anchor is
+// on endLine and reaches back to startLine with a MultilineMarker, which needs Bitbucket 8.0+.
+func (pd *PullRequestDiff) AnchorForRange(path string, startLine, endLine int) (*CommentAnchor, error) {
+	if startLine == endLine {
+		return pd.AnchorFor(path, startLine, "TO")
+	}
+	if endLine < startLine {
+		return nil, fmt.Errorf("end line %d is before start line %d", endLine, startLine)
+	}
+
+	diff, err := pd.commentableFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hunk := range diff.Hunks {
+		if startLine < hunk.DestinationLine || startLine >= hunk.DestinationLine+hunk.DestinationSpan {
+			continue
+		}
+
+		// Line types of the new side of the hunk by line number
+		lineTypes := make(map[int]string, hunk.DestinationSpan)
+		for _, segment := range hunk.Segments {
+			if segment.Type == "REMOVED" {
+				continue
+			}
+			for _, l := range segment.Lines {
+				lineTypes[l.Destination] = segment.Type
+			}
+		}
+		for line := startLine; line <= endLine; line++ {
+			if lineTypes[line] == "" {
+				return nil, fmt.Errorf("lines %d-%d of %s are not all in one hunk of the diff; lines in the diff: %s",
+					startLine, endLine, path, describeHunkRanges(diff, "TO"))
+			}
+		}
+
+		anchor := &CommentAnchor{
+			Line:     endLine,
+			LineType: lineTypes[endLine],
+			FileType: "TO",
+			Path:     diff.Path(),
+			MultilineMarker: &MultilineMarker{
+				StartLine:     startLine,
+				StartLineType: lineTypes[startLine],
+			},
+		}
+		if srcPath := diff.SourcePath(); srcPath != "" && srcPath != anchor.Path {
+			anchor.SrcPath = srcPath
+		}
+		return anchor, nil
+	}
+
+	return nil, fmt.Errorf("line %d (TO) of %s is not part of the diff; lines in the diff: %s",
+		startLine, path, describeHunkRanges(diff, "TO"))
+}
+
+// commentableFile returns the diff of a file that can have inline comments
+func (pd *PullRequestDiff) commentableFile(path string) (*Diff, error) {
+	diff := pd.FindFile(path)
+	if diff == nil {
+		return nil, fmt.Errorf("file %s is not changed in the pull request", path)
+	}
+	if diff.Binary {
+		return nil, fmt.Errorf("file %s is binary and cannot have inline comments", path)
+	}
+	return diff, nil
+}
+
+// CompleteAnchor checks an inline comment anchor against the diff and fills in the line type,
+// file type and source path. Anchors without a path or line (file or general comments) are left as is.
+func (pd *PullRequestDiff) CompleteAnchor(anchor *CommentAnchor) error {
+	if anchor == nil || anchor.Path == "" || anchor.Line <= 0 {
+		return nil
+	}
+
+	fileType := anchor.FileType
+	if fileType == "" {
+		fileType = "TO"
+		if anchor.LineType == "REMOVED" {
+			fileType = "FROM"
+		}
+	}
+
+	resolved, err := pd.AnchorFor(anchor.Path, anchor.Line, fileType)
+	if err != nil {
+		return err
+	}
+	if anchor.LineType != "" && anchor.LineType != resolved.LineType {
+		return fmt.Errorf("line %d (%s) of %s is a %s line, not %s", anchor.Line, fileType, anchor.Path, resolved.LineType, anchor.LineType)
+	}
+
+	anchor.LineType = resolved.LineType
+	anchor.FileType = resolved.FileType
+	anchor.Path = resolved.Path
+	if anchor.SrcPath == "" {
+		anchor.SrcPath = resolved.SrcPath
+	}
+	return nil
+}
+
+// describeHunkRanges lists the line ranges covered by the hunks of a file, e.g. "10-24, 80-95"
+func describeHunkRanges(diff *Diff, fileType string) string {
+	var ranges []string
+	for _, hunk := range diff.Hunks {
+		start, span := hunk.DestinationLine, hunk.DestinationSpan
+		if fileType == "FROM" {
+			start, span = hunk.SourceLine, hunk.SourceSpan
+		}
+		if span == 0 {
+			continue
+		}
+		ranges = append(ranges, fmt.Sprintf("%d-%d", start, start+span-1))
+	}
+	if len(ranges) == 0 {
+		return "none"
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// Stats counts the added and removed lines of a file diff
+func (d *Diff) Stats() (added, removed int) {
+	for _, hunk := range d.Hunks {
+		for _, segment := range hunk.Segments {
+			switch segment.Type {
+			case "ADDED":
+				added += len(segment.Lines)
+			case "REMOVED":
+				removed += len(segment.Lines)
+			}
+		}
+	}
+	return added, removed
+}
+
+// ChangeType describes how the file changed: ADDED, DELETED, RENAMED or MODIFIED
+func (d *Diff) ChangeType() string {
+	switch {
+	case d.Source == nil:
+		return "ADDED"
+	case d.Destination == nil:
+		return "DELETED"
+	case d.Source.ToString != d.Destination.ToString:
+		return "RENAMED"
+	default:
+		return "MODIFIED"
+	}
+}
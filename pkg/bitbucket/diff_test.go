@@ -0,0 +1,178 @@
+package bitbucket
+
+import (
+	"strings"
+	"testing"
+)
+
+// testDiff is a rename of old/name.go to new/name.go that replaces line 11 with two lines and a
+// binary image
+func testDiff() *PullRequestDiff {
+	return &PullRequestDiff{Diffs: []Diff{
+		{
+			Source:      &DiffPath{ToString: "old/name.go"},
+			Destination: &DiffPath{ToString: "new/name.go"},
+			Hunks: []Hunk{{
+				SourceLine: 10, SourceSpan: 3, DestinationLine: 10, DestinationSpan: 4,
+				Segments: []Segment{
+					{Type: "CONTEXT", Lines: []Line{{Source: 10, Destination: 10, Line: "a"}}},
+					{Type: "REMOVED", Lines: []Line{{Source: 11, Destination: 11, Line: "old"}}},
+					{Type: "ADDED", Lines: []Line{{Source: 12, Destination: 11, Line: "new"}, {Source: 12, Destination: 12, Line: "extra"}}},
+					{Type: "CONTEXT", Lines: []Line{{Source: 12, Destination: 13, Line: "b"}}},
+				},
+			}},
+		},
+		{
+			Source:      &DiffPath{ToString: "logo.png"},
+			Destination: &DiffPath{ToString: "logo.png"},
+			Binary:      true,
+		},
+	}}
+}
+
+func TestAnchorFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		line     int
+		fileType string
+		lineType string
+		err      string
+	}{
+		{name: "added line", path: "new/name.go", line: 12, fileType: "TO", lineType: "ADDED"},
+		{name: "removed line", path: "new/name.go", line: 11, fileType: "FROM", lineType: "REMOVED"},
+		{name: "context line in the new file", path: "new/name.go", line: 13, fileType: "TO", lineType: "CONTEXT"},
+		{name: "context line in the old file", path: "new/name.go", line: 12, fileType: "FROM", lineType: "CONTEXT"},
+		{name: "old path of a rename", path: "old/name.go", line: 10, fileType: "TO", lineType: "CONTEXT"},
+		{name: "line outside any hunk", path: "new/name.go", line: 40, fileType: "TO", err: "lines in the diff: 10-13"},
+		{name: "line of the removed one in the new file", path: "new/name.go", line: 11, fileType: "TO", lineType: "ADDED"},
+		{name: "file not in the diff", path: "other.go", line: 1, fileType: "TO", err: "not changed"},
+		{name: "binary file", path: "logo.png", line: 1, fileType: "TO", err: "binary"},
+		{name: "invalid file type", path: "new/name.go", line: 10, fileType: "BOTH", err: "invalid file type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchor, err := testDiff().AnchorFor(tt.path, tt.line, tt.fileType)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("AnchorFor error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AnchorFor: %v", err)
+			}
+			if anchor.LineType != tt.lineType || anchor.FileType != tt.fileType || anchor.Line != tt.line {
+				t.Errorf("anchor = %+v, want a %s line %d (%s)", anchor, tt.lineType, tt.line, tt.fileType)
+			}
+			if anchor.Path != "new/name.go" || anchor.SrcPath != "old/name.go" {
+				t.Errorf("anchor paths = %q from %q, want new/name.go from old/name.go", anchor.Path, anchor.SrcPath)
+			}
+		})
+	}
+}
+
+func TestCompleteAnchor(t *testing.T) {
+	tests := []struct {
+		name   string
+		anchor CommentAnchor
+		want   CommentAnchor
+		err    string
+	}{
+		{
+			name:   "line only",
+			anchor: CommentAnchor{Path: "new/name.go", Line: 11},
+			want:   CommentAnchor{Path: "new/name.go", Line: 11, LineType: "ADDED", FileType: "TO", SrcPath: "old/name.go"},
+		},
+		{
+			name:   "removed line type implies the old file",
+			anchor: CommentAnchor{Path: "new/name.go", Line: 11, LineType: "REMOVED"},
+			want:   CommentAnchor{Path: "new/name.go", Line: 11, LineType: "REMOVED", FileType: "FROM", SrcPath: "old/name.go"},
+		},
+		{
+			name:   "old path is replaced by the new one",
+			anchor: CommentAnchor{Path: "old/name.go", Line: 12, FileType: "FROM"},
+			want:   CommentAnchor{Path: "new/name.go", Line: 12, LineType: "CONTEXT", FileType: "FROM", SrcPath: "old/name.go"},
+		},
+		{
+			name:   "file comment is left as is",
+			anchor: CommentAnchor{Path: "new/name.go"},
+			want:   CommentAnchor{Path: "new/name.go"},
+		},
+		{
+			name:   "mismatched line type",
+			anchor: CommentAnchor{Path: "new/name.go", Line: 13, LineType: "ADDED"},
+			err:    "is a CONTEXT line, not ADDED",
+		},
+		{
+			name:   "line outside any hunk",
+			anchor: CommentAnchor{Path: "new/name.go", Line: 3, FileType: "FROM"},
+			err:    "lines in the diff: 10-12",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchor := tt.anchor
+			err := testDiff().CompleteAnchor(&anchor)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("CompleteAnchor error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompleteAnchor: %v", err)
+			}
+			if anchor != tt.want {
+				t.Errorf("anchor = %+v, want %+v", anchor, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnchorForRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		startLine int
+		endLine   int
+		lineType  string
+		startType string
+		err       string
+	}{
+		{name: "added lines", startLine: 11, endLine: 12, lineType: "ADDED", startType: "ADDED"},
+		{name: "context to context across the change", startLine: 10, endLine: 13, lineType: "CONTEXT", startType: "CONTEXT"},
+		{name: "single line has no marker", startLine: 12, endLine: 12, lineType: "ADDED"},
+		{name: "range leaving the hunk", startLine: 12, endLine: 15, err: "not all in one hunk"},
+		{name: "start outside any hunk", startLine: 2, endLine: 11, err: "not part of the diff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchor, err := testDiff().AnchorForRange("new/name.go", tt.startLine, tt.endLine)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("AnchorForRange error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AnchorForRange: %v", err)
+			}
+			if anchor.Line != tt.endLine || anchor.LineType != tt.lineType || anchor.FileType != "TO" {
+				t.Errorf("anchor = %+v, want a %s line %d (TO)", anchor, tt.lineType, tt.endLine)
+			}
+			marker := anchor.MultilineMarker
+			if tt.startType == "" {
+				if marker != nil {
+					t.Errorf("marker = %+v, want none for a single line", marker)
+				}
+				return
+			}
+			if marker == nil || marker.StartLine != tt.startLine || marker.StartLineType != tt.startType {
+				t.Errorf("marker = %+v, want a %s start line %d", marker, tt.startType, tt.startLine)
+			}
+		})
+	}
+}
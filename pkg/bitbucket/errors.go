@@ -0,0 +1,63 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrReadOnly is returned for requests that would modify data while the server is in read-only mode
+var ErrReadOnly = errors.New("the server is running in read-only mode (BITBUCKET_READ_ONLY)")
+
+// ErrUnknownUser is returned when Bitbucket accepts the credentials but the user they belong to
+// cannot be looked up
+var ErrUnknownUser = errors.New("cannot determine the current Bitbucket user")
+
+// APIError is returned when Bitbucket responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+	Body       string
+	Messages   []string // Messages from Bitbucket's errors array, when the body has one
+}
+
+func (e *APIError) Error() string {
+	if message := e.Message(); message != "" {
+		return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, message)
+	}
+	return fmt.Sprintf("API request failed with status %d", e.StatusCode)
+}
+
+// Message returns Bitbucket's error messages, or the raw body when it sent none
+func (e *APIError) Message() string {
+	if len(e.Messages) > 0 {
+		return strings.Join(e.Messages, "; ")
+	}
+	return e.Body
+}
+
+// newAPIError reads the response body into an APIError. The caller still closes the body.
+func newAPIError(resp *http.Response) *APIError {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(bodyBytes)),
+	}
+
+	var errorBody struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(bodyBytes, &errorBody) == nil {
+		for _, e := range errorBody.Errors {
+			if e.Message != "" {
+				apiErr.Messages = append(apiErr.Messages, e.Message)
+			}
+		}
+	}
+
+	return apiErr
+}
@@ -0,0 +1,54 @@
+package bitbucket
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MaxRawFileSize is the largest file GetRawFile returns
+const MaxRawFileSize = 1 << 20
+
+// RawFile is the content of a file at a given ref
+type RawFile struct {
+	Content     []byte
+	ContentType string // As reported by Bitbucket, may be empty
+}
+
+// GetRawFile returns the content of a file at a branch, tag or commit. Files larger than
+// MaxRawFileSize are refused rather than truncated.
+func (bs *Server) GetRawFile(projectKey, repoSlug, path, ref string) (*RawFile, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	endpoint := fmt.Sprintf("/projects/%s/repos/%s/raw/%s", projectKey, repoSlug, strings.Join(segments, "/"))
+	if ref != "" {
+		endpoint += "?at=" + url.QueryEscape(ref)
+	}
+
+	resp, err := bs.makeRequestWithAccept("GET", endpoint, nil, "*/*")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, MaxRawFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxRawFileSize {
+		return nil, fmt.Errorf("file %s is larger than %d bytes", path, MaxRawFileSize)
+	}
+
+	return &RawFile{
+		Content:     content,
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
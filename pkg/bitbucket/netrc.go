@@ -0,0 +1,121 @@
+package bitbucket
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DefaultNetrcFile returns $NETRC, or ~/.netrc (~/_netrc on Windows)
+func DefaultNetrcFile() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc"), nil
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// NetrcCredentials reads the login and password for host from a netrc file. The login and
+// password are used for basic auth; Bitbucket accepts a personal access token as the password.
+type NetrcCredentials struct {
+	Path string
+	Host string // Matched against the machine entries; the default entry is the fallback
+}
+
+func (n NetrcCredentials) Load() (Credentials, error) {
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer file.Close()
+
+	// macdef bodies run until an empty line and must not be read as tokens
+	var words []string
+	inMacro := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for i, field := range fields {
+			if field == "macdef" {
+				words = append(words, fields[:i]...)
+				fields = nil
+				inMacro = true
+				break
+			}
+		}
+		words = append(words, fields...)
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, err
+	}
+
+	var matched, fallback *Credentials
+	var current *Credentials
+	for i := 0; i < len(words); i++ {
+		switch words[i] {
+		case "machine":
+			current = nil
+			if i+1 < len(words) {
+				i++
+				if matched == nil && strings.EqualFold(words[i], n.Host) {
+					matched = &Credentials{}
+					current = matched
+				}
+			}
+		case "default":
+			current = nil
+			if fallback == nil {
+				fallback = &Credentials{}
+				current = fallback
+			}
+		case "login", "password", "account":
+			if i+1 >= len(words) {
+				break
+			}
+			i++
+			if current == nil {
+				continue
+			}
+			switch words[i-1] {
+			case "login":
+				current.Username = words[i]
+			case "password":
+				current.Password = words[i]
+			}
+		}
+	}
+
+	creds := matched
+	if creds == nil {
+		creds = fallback
+	}
+	if creds == nil || creds.Password == "" {
+		return Credentials{}, fmt.Errorf("no password for machine %s", n.Host)
+	}
+	// A password without a login is taken to be a token
+	if creds.Username == "" {
+		return Credentials{Token: creds.Password}, nil
+	}
+	return *creds, nil
+}
+
+func (n NetrcCredentials) String() string {
+	return fmt.Sprintf("machine %s in %s", n.Host, n.Path)
+}
@@ -0,0 +1,367 @@
+package bitbucket
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotLoggedIn is returned in OAuth mode when no token has been stored yet
+var ErrNotLoggedIn = errors.New("not logged in to Bitbucket")
+
+// OAuthConfig configures the OAuth 2.0 authorization code flow against an incoming application
+// link (Bitbucket Data Center 7.21+)
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string // Optional
+	AuthorizeURL string // Defaults to <BaseURL>/rest/oauth2/latest/authorize
+	TokenURL     string // Defaults to <BaseURL>/rest/oauth2/latest/token
+	RedirectURL  string // Loopback URL the login listens on, registered with the application link
+	Scopes       []string
+	TokenFile    string
+}
+
+// OAuthToken is what is stored in the token file
+type OAuthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// expired reports whether the token is about to expire, with some slack for clock skew
+func (t *OAuthToken) expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(30*time.Second).After(t.Expiry)
+}
+
+// DefaultOAuthTokenFile is where tokens are stored when OAuthConfig.TokenFile is empty. Each
+// configuration profile gets its own file; pass "" when there are no profiles.
+func DefaultOAuthTokenFile(profile string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	name := "bitbucket-oauth.json"
+	if profile != "" {
+		name = "bitbucket-oauth-" + profile + ".json"
+	}
+	return filepath.Join(dir, "bbcli", name), nil
+}
+
+// withDefaults fills in the endpoints derived from the Bitbucket base URL
+func (c OAuthConfig) withDefaults(baseURL string) OAuthConfig {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if c.AuthorizeURL == "" {
+		c.AuthorizeURL = baseURL + "/rest/oauth2/latest/authorize"
+	}
+	if c.TokenURL == "" {
+		c.TokenURL = baseURL + "/rest/oauth2/latest/token"
+	}
+	return c
+}
+
+// LoadOAuthToken reads a stored token. Files that other users can read or write are refused,
+// since the refresh token grants lasting access.
+func LoadOAuthToken(path string) (*OAuthToken, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: no token in %s, run `bbcli login`", ErrNotLoggedIn, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("token file %s is accessible by other users (mode %04o); run `chmod 600 %s`", path, info.Mode().Perm(), path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var token OAuthToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %w", path, err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%w: token file %s holds no access token, run `bbcli login`", ErrNotLoggedIn, path)
+	}
+	return &token, nil
+}
+
+// SaveOAuthToken writes a token readable only by the current user, replacing the file atomically
+func SaveOAuthToken(path string, token *OAuthToken) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".bitbucket-oauth-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// oauthTokenSource hands out the stored access token and refreshes it when it expires or
+// Bitbucket rejects it
+type oauthTokenSource struct {
+	config OAuthConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	token *OAuthToken
+}
+
+// accessToken returns a token to send, loading it from disk on first use
+func (ts *oauthTokenSource) accessToken() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token == nil {
+		token, err := LoadOAuthToken(ts.config.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		ts.token = token
+	}
+	if ts.token.expired() && ts.token.RefreshToken != "" {
+		if err := ts.refreshLocked(); err != nil {
+			return "", err
+		}
+	}
+	return ts.token.AccessToken, nil
+}
+
+// refresh replaces a token Bitbucket rejected. If the token was replaced since stale was sent,
+// by another request or another process sharing the token file, that token is used instead.
+func (ts *oauthTokenSource) refresh(stale string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != nil && ts.token.AccessToken != stale {
+		return nil
+	}
+	if stored, err := LoadOAuthToken(ts.config.TokenFile); err == nil && stored.AccessToken != stale {
+		ts.token = stored
+		return nil
+	}
+	if ts.token == nil || ts.token.RefreshToken == "" {
+		return fmt.Errorf("%w: the access token was rejected and cannot be refreshed, run `bbcli login`", ErrNotLoggedIn)
+	}
+	return ts.refreshLocked()
+}
+
+func (ts *oauthTokenSource) refreshLocked() error {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", ts.token.RefreshToken)
+	token, err := requestOAuthToken(ts.client, ts.config, form)
+	if err != nil {
+		return fmt.Errorf("failed to refresh OAuth token: %w", err)
+	}
+	// Servers that do not rotate refresh tokens leave it out of the response
+	if token.RefreshToken == "" {
+		token.RefreshToken = ts.token.RefreshToken
+	}
+	ts.token = token
+	return SaveOAuthToken(ts.config.TokenFile, token)
+}
+
+// requestOAuthToken posts a grant to the token endpoint
+func requestOAuthToken(client *http.Client, config OAuthConfig, form url.Values) (*OAuthToken, error) {
+	form.Set("client_id", config.ClientID)
+	if config.ClientSecret != "" {
+		form.Set("client_secret", config.ClientSecret)
+	}
+
+	resp, err := client.PostForm(config.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint returned no access token")
+	}
+
+	token := &OAuthToken{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		TokenType:    result.TokenType,
+	}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// OAuthLogin runs the authorization code flow with PKCE: it listens on the loopback redirect
+// URL, passes the authorization URL to open for the user to approve access, and exchanges the
+// returned code for a token. The token is saved to the configured token file and returned.
+func (bs *Server) OAuthLogin(ctx context.Context, open func(authorizeURL string)) (*OAuthToken, error) {
+	if bs.oauth == nil {
+		return nil, fmt.Errorf("OAuth is not configured")
+	}
+	config := bs.oauth.config
+
+	redirect, err := url.Parse(config.RedirectURL)
+	if err != nil || redirect.Scheme != "http" || redirect.Port() == "" {
+		return nil, fmt.Errorf("invalid redirect URL %q: must be http://127.0.0.1:<port>/<path> or http://localhost:<port>/<path>", config.RedirectURL)
+	}
+	if host := redirect.Hostname(); host != "localhost" && !net.ParseIP(host).IsLoopback() {
+		return nil, fmt.Errorf("invalid redirect URL %q: the callback must listen on a loopback address", config.RedirectURL)
+	}
+
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomURLString(16)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("client_id", config.ClientID)
+	params.Set("response_type", "code")
+	params.Set("redirect_uri", config.RedirectURL)
+	params.Set("scope", strings.Join(config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	authorizeURL := config.AuthorizeURL + "?" + params.Encode()
+
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the OAuth callback on %s: %w", redirect.Host, err)
+	}
+
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	results := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	callbackPath := redirect.Path
+	if callbackPath == "" {
+		callbackPath = "/"
+	}
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var result callbackResult
+		switch {
+		case query.Get("state") != state:
+			result.err = fmt.Errorf("OAuth callback with unexpected state")
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("authorization denied: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			result.err = fmt.Errorf("OAuth callback without a code")
+		default:
+			result.code = query.Get("code")
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if result.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<p>Login failed: %s</p>", html.EscapeString(result.err.Error()))
+		} else {
+			fmt.Fprint(w, "<p>Logged in to Bitbucket. You can close this window.</p>")
+		}
+
+		select {
+		case results <- result:
+		default:
+		}
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	open(authorizeURL)
+
+	var result callbackResult
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", result.code)
+	form.Set("redirect_uri", config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	token, err := requestOAuthToken(bs.client, config, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the authorization code: %w", err)
+	}
+
+	if err := SaveOAuthToken(config.TokenFile, token); err != nil {
+		return nil, fmt.Errorf("failed to save token: %w", err)
+	}
+
+	bs.oauth.mu.Lock()
+	bs.oauth.token = token
+	bs.oauth.mu.Unlock()
+	return token, nil
+}
+
+func randomURLString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
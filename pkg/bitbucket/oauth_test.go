@@ -0,0 +1,264 @@
+package bitbucket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// freeLoopbackURL returns a callback URL on a loopback port nothing listens on
+func freeLoopbackURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + addr + "/callback"
+}
+
+// stubAuthorizationServer answers the token endpoint of an application link. Authorization codes
+// are only exchanged together with the verifier of the challenge sent to the authorize endpoint.
+type stubAuthorizationServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	challenge string
+	code      string
+	grants    []url.Values
+}
+
+func newStubAuthorizationServer(t *testing.T) *stubAuthorizationServer {
+	stub := &stubAuthorizationServer{code: "the-code"}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/oauth2/latest/token" || r.Method != "POST" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.grants = append(stub.grants, r.PostForm)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			verified := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if r.PostForm.Get("code") != stub.code || base64.RawURLEncoding.EncodeToString(verified[:]) != stub.challenge {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, `{"access_token":"first","refresh_token":"refresh-1","token_type":"bearer","expires_in":3600}`)
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != "refresh-1" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, `{"access_token":"fresh","token_type":"bearer","expires_in":3600}`)
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func newOAuthTestConfig(t *testing.T) OAuthConfig {
+	return OAuthConfig{
+		ClientID:    "client",
+		RedirectURL: freeLoopbackURL(t),
+		Scopes:      []string{"REPO_WRITE"},
+		TokenFile:   filepath.Join(t.TempDir(), "token.json"),
+	}
+}
+
+func TestOAuthLogin(t *testing.T) {
+	stub := newStubAuthorizationServer(t)
+	config := newOAuthTestConfig(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bs := NewServer(&Config{BaseURL: stub.URL, OAuth: &config})
+	token, err := bs.OAuthLogin(ctx, func(authorizeURL string) {
+		authorize, err := url.Parse(authorizeURL)
+		if err != nil {
+			t.Errorf("invalid authorization URL: %v", err)
+			return
+		}
+		query := authorize.Query()
+		if authorize.Path != "/rest/oauth2/latest/authorize" {
+			t.Errorf("authorize path = %q", authorize.Path)
+		}
+		if query.Get("client_id") != "client" || query.Get("response_type") != "code" || query.Get("scope") != "REPO_WRITE" {
+			t.Errorf("unexpected authorization parameters: %v", query)
+		}
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+			t.Errorf("authorization URL without a PKCE challenge: %v", query)
+		}
+		stub.mu.Lock()
+		stub.challenge = query.Get("code_challenge")
+		stub.mu.Unlock()
+
+		// The browser comes back from Bitbucket with the code and the state
+		callback := query.Get("redirect_uri") + "?" + url.Values{"code": {stub.code}, "state": {query.Get("state")}}.Encode()
+		resp, err := http.Get(callback)
+		if err != nil {
+			t.Errorf("callback failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("callback status = %d", resp.StatusCode)
+		}
+	})
+	if err != nil {
+		t.Fatalf("OAuthLogin: %v", err)
+	}
+	if token.AccessToken != "first" || token.RefreshToken != "refresh-1" {
+		t.Errorf("token = %+v", token)
+	}
+
+	stored, err := LoadOAuthToken(config.TokenFile)
+	if err != nil {
+		t.Fatalf("stored token: %v", err)
+	}
+	if stored.AccessToken != "first" {
+		t.Errorf("stored access token = %q", stored.AccessToken)
+	}
+	if grant := stub.grants[0]; grant.Get("redirect_uri") != config.RedirectURL || grant.Get("client_id") != "client" {
+		t.Errorf("unexpected code exchange: %v", grant)
+	}
+}
+
+func TestOAuthLoginRejectsUnexpectedState(t *testing.T) {
+	stub := newStubAuthorizationServer(t)
+	config := newOAuthTestConfig(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bs := NewServer(&Config{BaseURL: stub.URL, OAuth: &config})
+	_, err := bs.OAuthLogin(ctx, func(authorizeURL string) {
+		authorize, _ := url.Parse(authorizeURL)
+		callback := authorize.Query().Get("redirect_uri") + "?" + url.Values{"code": {stub.code}, "state": {"forged"}}.Encode()
+		resp, err := http.Get(callback)
+		if err != nil {
+			t.Errorf("callback failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("callback status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+	if err == nil || !strings.Contains(err.Error(), "state") {
+		t.Fatalf("OAuthLogin error = %v, want an unexpected state error", err)
+	}
+	if len(stub.grants) != 0 {
+		t.Errorf("the code was exchanged despite the forged state")
+	}
+	if _, err := os.Stat(config.TokenFile); !os.IsNotExist(err) {
+		t.Errorf("a token file was written: %v", err)
+	}
+}
+
+func TestOAuthRefreshOnUnauthorized(t *testing.T) {
+	stub := newStubAuthorizationServer(t)
+
+	// Bitbucket only accepts the refreshed token
+	var bodies []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer api.Close()
+
+	config := newOAuthTestConfig(t)
+	config.TokenURL = stub.URL + "/rest/oauth2/latest/token"
+	bs := NewServer(&Config{BaseURL: api.URL, OAuth: &config})
+	// Not expired yet, so only the 401 reveals that it was revoked
+	err := SaveOAuthToken(config.TokenFile, &OAuthToken{
+		AccessToken:  "stale",
+		RefreshToken: "refresh-1",
+		Expiry:       time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := `{"text":"Looks good"}`
+	resp, err := bs.makeRawRequest("POST", "/rest/api/1.0/projects/P/repos/r/pull-requests/1/comments", strings.NewReader(payload), "application/json")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want the retried request to succeed", resp.StatusCode)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("Bitbucket got %d requests, want the rejected one and the retry", len(bodies))
+	}
+	for i, body := range bodies {
+		if body != payload {
+			t.Errorf("request %d body = %q, want %q", i+1, body, payload)
+		}
+	}
+	if len(stub.grants) != 1 || stub.grants[0].Get("grant_type") != "refresh_token" {
+		t.Errorf("token endpoint grants = %v, want one refresh", stub.grants)
+	}
+
+	stored, err := LoadOAuthToken(config.TokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stub does not rotate refresh tokens, so the old one is kept
+	if stored.AccessToken != "fresh" || stored.RefreshToken != "refresh-1" {
+		t.Errorf("stored token = %+v", stored)
+	}
+}
+
+func TestLoadOAuthTokenRefusesSharedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := SaveOAuthToken(path, &OAuthToken{AccessToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOAuthToken(path); err != nil {
+		t.Fatalf("private token file refused: %v", err)
+	}
+
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadOAuthToken(path)
+	if err == nil || !strings.Contains(err.Error(), "accessible by other users") {
+		t.Fatalf("LoadOAuthToken error = %v, want a refusal of the readable file", err)
+	}
+}
@@ -1,12 +1,19 @@
 package bitbucket
 
+import "net/http"
+
 // Configuration for Bitbucket Server API
 type Config struct {
+	Profile           string // Configuration profile name, for tokens scoped with ContextWithProfileToken
 	BaseURL           string
 	Username          string
 	Password          string // App password or personal access token
 	Token             string
+	Credentials       CredentialSource // Load credentials from a file, command or netrc instead
+	OAuth             *OAuthConfig     // Use OAuth 2.0 tokens stored by OAuthLogin instead of the above
 	DefaultProjectKey string
+	ReadOnly          bool         // Block every request that is not a GET
+	HTTPClient        *http.Client // Sends the requests, e.g. with custom TLS or proxy settings; optional
 }
 
 // Bitbucket API structures
@@ -23,13 +30,37 @@ type PullRequest struct {
 	FromRef      PullRequestRef         `json:"fromRef"`
 	ToRef        PullRequestRef         `json:"toRef"`
 	Locked       bool                   `json:"locked"`
-	Author       User                   `json:"author"`
+	Author       Participant            `json:"author"`
 	Reviewers    []Reviewer             `json:"reviewers"`
 	Participants []Participant          `json:"participants"`
 	Properties   map[string]interface{} `json:"properties"`
 	Links        map[string]interface{} `json:"links"`
 }
 
+// PullRequestListOptions holds the filters supported by the repository pull request listing
+type PullRequestListOptions struct {
+	State          string // OPEN, MERGED, DECLINED or ALL
+	Direction      string // INCOMING or OUTGOING, relative to At
+	At             string // Fully qualified ref, e.g. refs/heads/main
+	Order          string // NEWEST or OLDEST
+	WithAttributes *bool
+	WithProperties *bool
+	Participants   []ParticipantFilter
+	Limit          int
+
+	// Match filters pull requests on the client side. At most MaxScan pull requests are
+	// fetched to find Limit matches (0 means no bound).
+	Match   func(PullRequest) bool
+	MaxScan int
+}
+
+// ParticipantFilter restricts a pull request listing to those with a given participant
+type ParticipantFilter struct {
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`     // AUTHOR, REVIEWER or PARTICIPANT
+	Approved *bool  `json:"approved,omitempty"` // Only when the participant has (not) approved
+}
+
 type PullRequestRef struct {
 	ID           string     `json:"id"`
 	DisplayID    string     `json:"displayId"`
@@ -95,18 +126,19 @@ type PullRequestActivity struct {
 }
 
 type Activity struct {
-	ID               int         `json:"id"`
-	CreatedDate      int64       `json:"createdDate"`
-	User             User        `json:"user"`
-	Action           string      `json:"action"`
-	CommentAction    string      `json:"commentAction,omitempty"`
-	Comment          *Comment    `json:"comment,omitempty"`
-	FromHash         string      `json:"fromHash,omitempty"`
-	PreviousFromHash string      `json:"previousFromHash,omitempty"`
-	PreviousToHash   string      `json:"previousToHash,omitempty"`
-	ToHash           string      `json:"toHash,omitempty"`
-	Added            *CommitList `json:"added,omitempty"`
-	Removed          *CommitList `json:"removed,omitempty"`
+	ID               int            `json:"id"`
+	CreatedDate      int64          `json:"createdDate"`
+	User             User           `json:"user"`
+	Action           string         `json:"action"`
+	CommentAction    string         `json:"commentAction,omitempty"`
+	Comment          *Comment       `json:"comment,omitempty"`
+	CommentAnchor    *CommentAnchor `json:"commentAnchor,omitempty"`
+	FromHash         string         `json:"fromHash,omitempty"`
+	PreviousFromHash string         `json:"previousFromHash,omitempty"`
+	PreviousToHash   string         `json:"previousToHash,omitempty"`
+	ToHash           string         `json:"toHash,omitempty"`
+	Added            *CommitList    `json:"added,omitempty"`
+	Removed          *CommitList    `json:"removed,omitempty"`
 }
 
 type Comment struct {
@@ -117,6 +149,10 @@ type Comment struct {
 	Author              User                   `json:"author"`
 	CreatedDate         int64                  `json:"createdDate"`
 	UpdatedDate         int64                  `json:"updatedDate"`
+	State               string                 `json:"state,omitempty"`    // OPEN or RESOLVED (Bitbucket 7.x+)
+	Severity            string                 `json:"severity,omitempty"` // NORMAL or BLOCKER (Bitbucket 7.x+)
+	ThreadResolved      bool                   `json:"threadResolved,omitempty"`
+	Anchor              *CommentAnchor         `json:"anchor,omitempty"`
 	Comments            []Comment              `json:"comments"`
 	Tasks               []Task                 `json:"tasks"`
 	PermittedOperations interface{}            `json:"permittedOperations"`
@@ -144,17 +180,91 @@ type TaskAnchor struct {
 	Properties map[string]interface{} `json:"properties"`
 }
 
+type TaskList struct {
+	Values     []Task `json:"values"`
+	Size       int    `json:"size"`
+	Limit      int    `json:"limit"`
+	IsLastPage bool   `json:"isLastPage"`
+	Start      int    `json:"start"`
+}
+
+type ApplicationProperties struct {
+	Version     string `json:"version"`
+	BuildNumber string `json:"buildNumber"`
+	BuildDate   string `json:"buildDate"`
+	DisplayName string `json:"displayName"`
+}
+
 type CommentAnchor struct {
-	Line         int    `json:"line,omitempty"`
-	LineType     string `json:"lineType,omitempty"`
-	Path         string `json:"path,omitempty"`
-	FileType     string `json:"fileType,omitempty"`
-	FromHash     string `json:"fromHash,omitempty"`
-	ToHash       string `json:"toHash,omitempty"`
-	SrcPath      string `json:"srcPath,omitempty"`
-	DstPath      string `json:"dstPath,omitempty"`
-	DiffType     string `json:"diffType,omitempty"`
-	OrphanedType string `json:"orphanedType,omitempty"`
+	Line            int              `json:"line,omitempty"`
+	LineType        string           `json:"lineType,omitempty"`
+	Path            string           `json:"path,omitempty"`
+	FileType        string           `json:"fileType,omitempty"`
+	FromHash        string           `json:"fromHash,omitempty"`
+	ToHash          string           `json:"toHash,omitempty"`
+	SrcPath         string           `json:"srcPath,omitempty"`
+	DstPath         string           `json:"dstPath,omitempty"`
+	DiffType        string           `json:"diffType,omitempty"`
+	OrphanedType    string           `json:"orphanedType,omitempty"`
+	MultilineMarker *MultilineMarker `json:"multilineMarker,omitempty"`
+}
+
+// MultilineMarker extends an inline comment anchor backwards so it spans several lines
+type MultilineMarker struct {
+	StartLine     int    `json:"startLine"`
+	StartLineType string `json:"startLineType"`
+}
+
+// PullRequestDiff is the JSON diff model returned by the diff endpoint with Accept: application/json
+type PullRequestDiff struct {
+	FromHash     string `json:"fromHash"`
+	ToHash       string `json:"toHash"`
+	ContextLines int    `json:"contextLines"`
+	Whitespace   string `json:"whitespace"`
+	Diffs        []Diff `json:"diffs"`
+	Truncated    bool   `json:"truncated"`
+}
+
+type Diff struct {
+	Source      *DiffPath              `json:"source"`
+	Destination *DiffPath              `json:"destination"`
+	Hunks       []Hunk                 `json:"hunks"`
+	Truncated   bool                   `json:"truncated"`
+	Binary      bool                   `json:"binary,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+}
+
+type DiffPath struct {
+	Components []string `json:"components"`
+	Parent     string   `json:"parent"`
+	Name       string   `json:"name"`
+	Extension  string   `json:"extension"`
+	ToString   string   `json:"toString"`
+}
+
+type Hunk struct {
+	Context         string    `json:"context,omitempty"`
+	SourceLine      int       `json:"sourceLine"`
+	SourceSpan      int       `json:"sourceSpan"`
+	DestinationLine int       `json:"destinationLine"`
+	DestinationSpan int       `json:"destinationSpan"`
+	Segments        []Segment `json:"segments"`
+	Truncated       bool      `json:"truncated"`
+}
+
+type Segment struct {
+	Type      string `json:"type"` // ADDED, REMOVED or CONTEXT
+	Lines     []Line `json:"lines"`
+	Truncated bool   `json:"truncated"`
+}
+
+type Line struct {
+	Source         int    `json:"source"`
+	Destination    int    `json:"destination"`
+	Line           string `json:"line"`
+	Truncated      bool   `json:"truncated"`
+	ConflictMarker string `json:"conflictMarker,omitempty"`
+	CommentIDs     []int  `json:"commentIds,omitempty"`
 }
 
 type CommitList struct {
@@ -186,6 +296,20 @@ type CommitParent struct {
 	DisplayID string `json:"displayId"`
 }
 
+// MergeStatus is the result of the merge checks of a pull request
+type MergeStatus struct {
+	CanMerge   bool        `json:"canMerge"`
+	Conflicted bool        `json:"conflicted"`
+	Outcome    string      `json:"outcome"` // CLEAN, CONFLICTED or UNKNOWN
+	Vetoes     []MergeVeto `json:"vetoes"`
+}
+
+// MergeVeto is a merge check that blocks the merge, such as missing approvals or open tasks
+type MergeVeto struct {
+	SummaryMessage  string `json:"summaryMessage"`
+	DetailedMessage string `json:"detailedMessage"`
+}
+
 type PullRequestSettings struct {
 	MergeConfig              *MergeConfig `json:"mergeConfig,omitempty"`
 	RequiredApprovers        int          `json:"requiredApprovers,omitempty"`
@@ -215,10 +339,16 @@ type DefaultReviewer struct {
 }
 
 type RefMatcher struct {
-	ID        string `json:"id"`
-	Type      string `json:"type"`
-	Active    bool   `json:"active"`
-	DisplayID string `json:"displayId"`
+	ID        string         `json:"id"`
+	Type      RefMatcherType `json:"type"`
+	Active    bool           `json:"active"`
+	DisplayID string         `json:"displayId"`
+}
+
+// RefMatcherType is BRANCH, PATTERN, MODEL_BRANCH, MODEL_CATEGORY or ANY_REF
+type RefMatcherType struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
 type MergeConfig struct {
@@ -0,0 +1,228 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolArgs reads and validates the arguments of a tool call. Problems are collected rather than
+// returned one at a time, so a single error result can tell the caller everything to fix.
+type toolArgs struct {
+	raw      map[string]interface{}
+	problems []string
+}
+
+func parseArgs(request mcp.CallToolRequest) *toolArgs {
+	raw := request.GetArguments()
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	return &toolArgs{raw: raw}
+}
+
+func (a *toolArgs) problemf(format string, args ...interface{}) {
+	a.problems = append(a.problems, fmt.Sprintf(format, args...))
+}
+
+// Has reports whether an argument was passed
+func (a *toolArgs) Has(name string) bool {
+	value, ok := a.raw[name]
+	return ok && value != nil
+}
+
+// String returns an optional string argument, empty when missing
+func (a *toolArgs) String(name string) string {
+	value, ok := a.raw[name]
+	if !ok || value == nil {
+		return ""
+	}
+	text, ok := value.(string)
+	if !ok {
+		a.problemf("%s must be a string", name)
+	}
+	return text
+}
+
+// RequiredString returns a string argument that must be present and not blank
+func (a *toolArgs) RequiredString(name string) string {
+	if !a.Has(name) {
+		a.problemf("%s is required", name)
+		return ""
+	}
+	text := a.String(name)
+	if text == "" {
+		a.problemf("%s must not be empty", name)
+	}
+	return text
+}
+
+// Bool returns an optional boolean argument
+func (a *toolArgs) Bool(name string, defaultValue bool) bool {
+	if !a.Has(name) {
+		return defaultValue
+	}
+	switch value := a.raw[name].(type) {
+	case bool:
+		return value
+	case string:
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	a.problemf("%s must be true or false", name)
+	return defaultValue
+}
+
+// OptionalBool returns a boolean argument, or nil when it was not passed
+func (a *toolArgs) OptionalBool(name string) *bool {
+	if !a.Has(name) {
+		return nil
+	}
+	value := a.Bool(name, false)
+	return &value
+}
+
+// ID returns a required positive integer argument such as pull_request_id
+func (a *toolArgs) ID(name string) int {
+	if !a.Has(name) {
+		a.problemf("%s is required", name)
+		return 0
+	}
+	return a.OptionalID(name)
+}
+
+// OptionalID returns a positive integer argument, 0 when missing
+func (a *toolArgs) OptionalID(name string) int {
+	if !a.Has(name) {
+		return 0
+	}
+	id, ok := a.integer(name)
+	if ok && id <= 0 {
+		a.problemf("%s must be a positive integer, got %d", name, id)
+		return 0
+	}
+	return id
+}
+
+// Int returns an optional integer argument within [min, max]
+func (a *toolArgs) Int(name string, defaultValue, min, max int) int {
+	if !a.Has(name) {
+		return defaultValue
+	}
+	value, ok := a.integer(name)
+	if !ok {
+		return defaultValue
+	}
+	if value < min || value > max {
+		a.problemf("%s must be between %d and %d, got %d", name, min, max, value)
+		return defaultValue
+	}
+	return value
+}
+
+// Number returns an optional non-negative number argument
+func (a *toolArgs) Number(name string) float64 {
+	if !a.Has(name) {
+		return 0
+	}
+	value, ok := a.raw[name].(float64)
+	if !ok || value < 0 {
+		a.problemf("%s must be a non-negative number", name)
+		return 0
+	}
+	return value
+}
+
+// integer accepts JSON numbers without a fraction and numeric strings
+func (a *toolArgs) integer(name string) (int, bool) {
+	switch value := a.raw[name].(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) <= math.MaxInt32 {
+			return int(value), true
+		}
+	case string:
+		if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return parsed, true
+		}
+	}
+	a.problemf("%s must be an integer", name)
+	return 0, false
+}
+
+// Enum returns an optional string argument that must be one of the allowed values
+func (a *toolArgs) Enum(name, defaultValue string, allowed ...string) string {
+	value := a.String(name)
+	if value == "" {
+		return defaultValue
+	}
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	a.problemf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+	return defaultValue
+}
+
+// Timestamp returns an optional time argument in epoch milliseconds, 0 when missing
+func (a *toolArgs) Timestamp(name string) int64 {
+	value := a.String(name)
+	if value == "" {
+		return 0
+	}
+	millis, err := parseTimestamp(value)
+	if err != nil {
+		a.problemf("%s: %v", name, err)
+	}
+	return millis
+}
+
+// Regexp returns an optional case-insensitive regular expression argument, nil when missing
+func (a *toolArgs) Regexp(name string) *regexp.Regexp {
+	pattern := a.String(name)
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		a.problemf("%s is not a valid regular expression: %v", name, err)
+	}
+	return re
+}
+
+// ProjectKey returns the project_key argument or the configured default project key
+func (a *toolArgs) ProjectKey(bb *bitbucket.Server) string {
+	if projectKey := a.String("project_key"); projectKey != "" {
+		return projectKey
+	}
+	defaultKey := bb.GetDefaultProjectKey()
+	if defaultKey == "" {
+		a.problemf("project_key is required because no default project key is configured (BITBUCKET_DEFAULT_PROJECT_KEY)")
+	}
+	return defaultKey
+}
+
+// Output returns the validated format and fields arguments
+func (a *toolArgs) Output(defaultFormat string) outputOptions {
+	opts := outputOptions{Format: a.Enum("format", defaultFormat, formatJSON, formatCompact, formatMarkdown)}
+	for _, field := range strings.Split(a.String("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			opts.Fields = append(opts.Fields, field)
+		}
+	}
+	return opts
+}
+
+// Err returns the collected problems as a tool error, or nil when all arguments are valid
+func (a *toolArgs) Err() error {
+	if len(a.problems) == 0 {
+		return nil
+	}
+	return &toolError{message: "Invalid arguments:\n- " + strings.Join(a.problems, "\n- ") + "\nFix the arguments and call the tool again."}
+}
@@ -0,0 +1,243 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// commentThreadGroup holds the threads anchored to one file. General comments have an empty path.
+type commentThreadGroup struct {
+	Path    string              `json:"path,omitempty"`
+	Threads []bitbucket.Comment `json:"threads"`
+}
+
+func RegisterListPullRequestComments(s *server.MCPServer, bb *bitbucket.Server) {
+	listCommentsTool := mcp.NewTool("list_pull_request_comments",
+		mcp.WithDescription("List pull request comments as threads (root comment with nested replies), grouped by file and line for inline comments"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithBoolean("unresolved_only",
+			mcp.Description("Only return threads that are not resolved (default: false)"),
+		),
+		mcp.WithString("author",
+			mcp.Description("Only return threads with a comment by this user (username, slug, display name or email)"),
+		),
+		mcp.WithString("since",
+			mcp.Description("Only return threads with comments created or updated at or after this time (RFC 3339, YYYY-MM-DD or epoch milliseconds)"),
+		),
+		withFormat(formatMarkdown),
+		withFields(),
+		withOutputSchema[valuesResult[commentThreadGroup]](),
+	)
+
+	s.AddTool(listCommentsTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		unresolvedOnly := args.Bool("unresolved_only", false)
+		author := args.String("author")
+		since := args.Timestamp("since")
+		output := args.Output(formatMarkdown)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		threads, err := bb.GetPullRequestCommentThreads(projectKey, repoSlug, pullRequestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request comments: %w", err)
+		}
+
+		var filtered []bitbucket.Comment
+		for _, thread := range threads {
+			if unresolvedOnly && (thread.State == "RESOLVED" || thread.ThreadResolved) {
+				continue
+			}
+			if author != "" && !threadHasAuthor(thread, author) {
+				continue
+			}
+			if since > 0 && threadLastActivity(thread) < since {
+				continue
+			}
+			filtered = append(filtered, thread)
+		}
+
+		groups := groupCommentThreads(filtered)
+
+		// Threads read best as nested markdown; other formats and field selection use the shared layer
+		if useCustomMarkdown(output) {
+			return structuredResult(groups, renderCommentThreadsMarkdown(groups), nil)
+		}
+
+		return formatResult(groups, output)
+	}))
+}
+
+// groupCommentThreads puts general threads first, then inline threads by path and line
+func groupCommentThreads(threads []bitbucket.Comment) []commentThreadGroup {
+	byPath := make(map[string][]bitbucket.Comment)
+	for _, thread := range threads {
+		path := ""
+		if thread.Anchor != nil {
+			path = thread.Anchor.Path
+		}
+		byPath[path] = append(byPath[path], thread)
+	}
+
+	paths := make([]string, 0, len(byPath))
+	for path := range byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	groups := make([]commentThreadGroup, 0, len(paths))
+	for _, path := range paths {
+		pathThreads := byPath[path]
+		sort.SliceStable(pathThreads, func(i, j int) bool {
+			li, lj := commentLine(pathThreads[i]), commentLine(pathThreads[j])
+			if li != lj {
+				return li < lj
+			}
+			return pathThreads[i].ID < pathThreads[j].ID
+		})
+		groups = append(groups, commentThreadGroup{Path: path, Threads: pathThreads})
+	}
+
+	return groups
+}
+
+func renderCommentThreadsMarkdown(groups []commentThreadGroup) string {
+	if len(groups) == 0 {
+		return "No comments found."
+	}
+
+	var sb strings.Builder
+	for i, group := range groups {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		if group.Path == "" {
+			sb.WriteString("## General\n")
+		} else {
+			fmt.Fprintf(&sb, "## %s\n", group.Path)
+		}
+
+		for _, thread := range group.Threads {
+			prefix := ""
+			if thread.Anchor != nil && thread.Anchor.Line > 0 {
+				prefix = fmt.Sprintf("L%d", thread.Anchor.Line)
+				if thread.Anchor.LineType != "" {
+					prefix += " " + thread.Anchor.LineType
+				}
+				prefix = "[" + prefix + "] "
+			}
+			writeCommentMarkdown(&sb, thread, 0, prefix)
+		}
+	}
+
+	return sb.String()
+}
+
+func writeCommentMarkdown(sb *strings.Builder, comment bitbucket.Comment, depth int, prefix string) {
+	indent := strings.Repeat("  ", depth)
+
+	var flags []string
+	if comment.State == "RESOLVED" || comment.ThreadResolved {
+		flags = append(flags, "RESOLVED")
+	}
+	if comment.Severity == "BLOCKER" {
+		flags = append(flags, "TASK")
+	}
+	flagText := ""
+	if len(flags) > 0 {
+		flagText = " [" + strings.Join(flags, ", ") + "]"
+	}
+
+	lines := strings.Split(strings.TrimSpace(comment.Text), "\n")
+	fmt.Fprintf(sb, "%s- %s#%d %s, %s%s: %s\n", indent, prefix, comment.ID, comment.Author.DisplayName,
+		formatTimestamp(comment.CreatedDate), flagText, lines[0])
+	for _, line := range lines[1:] {
+		fmt.Fprintf(sb, "%s  %s\n", indent, line)
+	}
+
+	for _, reply := range comment.Comments {
+		writeCommentMarkdown(sb, reply, depth+1, "")
+	}
+}
+
+func commentLine(comment bitbucket.Comment) int {
+	if comment.Anchor == nil {
+		return 0
+	}
+	return comment.Anchor.Line
+}
+
+func threadHasAuthor(comment bitbucket.Comment, author string) bool {
+	if userMatches(comment.Author, author) {
+		return true
+	}
+	for _, reply := range comment.Comments {
+		if threadHasAuthor(reply, author) {
+			return true
+		}
+	}
+	return false
+}
+
+func userMatches(user bitbucket.User, name string) bool {
+	return strings.EqualFold(user.Name, name) ||
+		strings.EqualFold(user.Slug, name) ||
+		strings.EqualFold(user.DisplayName, name) ||
+		strings.EqualFold(user.EmailAddress, name)
+}
+
+// threadLastActivity returns the latest created or updated timestamp in a thread
+func threadLastActivity(comment bitbucket.Comment) int64 {
+	latest := comment.CreatedDate
+	if comment.UpdatedDate > latest {
+		latest = comment.UpdatedDate
+	}
+	for _, reply := range comment.Comments {
+		if replyLatest := threadLastActivity(reply); replyLatest > latest {
+			latest = replyLatest
+		}
+	}
+	return latest
+}
+
+// parseTimestamp accepts RFC 3339, YYYY-MM-DD or epoch milliseconds and returns epoch milliseconds
+func parseTimestamp(value string) (int64, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid timestamp %q: use RFC 3339, YYYY-MM-DD or epoch milliseconds", value)
+}
+
+func formatTimestamp(millis int64) string {
+	return time.UnixMilli(millis).UTC().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,231 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// completionCacheTTL is how long listings fetched for completion are reused. Completion requests
+// arrive on every keystroke, so the listings are fetched once and filtered locally.
+const completionCacheTTL = time.Minute
+
+// completionListLimit bounds how many projects, repositories, branches or pull requests are
+// fetched for one listing
+const completionListLimit = 1000
+
+// maxCompletionValues is the most values a completion result may hold
+const maxCompletionValues = 100
+
+// CompletionProvider completes the project, repository, branch and pull request arguments of the
+// resource templates and prompts. Arguments are recognized by name, so both the template variables
+// (project, repo, ref, id) and the prompt arguments (project_key, repo_slug, pull_request_id) work.
+type CompletionProvider struct {
+	bb *bitbucket.Server
+
+	mu    sync.Mutex
+	cache map[string]completionCacheEntry // Keyed by client and listing
+}
+
+type completionCacheEntry struct {
+	candidates []completionCandidate
+	expires    time.Time
+}
+
+// completionCandidate is a value that can be completed, plus extra text it can be found by, such
+// as a pull request's title
+type completionCandidate struct {
+	value    string
+	keywords string
+}
+
+func NewCompletionProvider(bb *bitbucket.Server) *CompletionProvider {
+	return &CompletionProvider{
+		bb:    bb,
+		cache: make(map[string]completionCacheEntry),
+	}
+}
+
+func (p *CompletionProvider) CompletePromptArgument(ctx context.Context, promptName string, argument mcp.CompleteArgument, completeContext mcp.CompleteContext) (*mcp.Completion, error) {
+	return p.complete(p.bb.ForContext(ctx), argument, completeContext)
+}
+
+func (p *CompletionProvider) CompleteResourceArgument(ctx context.Context, uri string, argument mcp.CompleteArgument, completeContext mcp.CompleteContext) (*mcp.Completion, error) {
+	if !strings.HasPrefix(uri, "bitbucket://") {
+		return &mcp.Completion{Values: []string{}}, nil
+	}
+	return p.complete(p.bb.ForContext(ctx), argument, completeContext)
+}
+
+// complete lists the candidates with the client of the requesting user, since users can see
+// different projects and repositories
+func (p *CompletionProvider) complete(bb *bitbucket.Server, argument mcp.CompleteArgument, completeContext mcp.CompleteContext) (*mcp.Completion, error) {
+	projectKey := contextArgument(completeContext, "project_key", "project")
+	if projectKey == "" {
+		projectKey = bb.GetDefaultProjectKey()
+	}
+	repoSlug := contextArgument(completeContext, "repo_slug", "repo")
+
+	var candidates []completionCandidate
+	var err error
+	switch argument.Name {
+	case "project_key", "project":
+		candidates, err = p.projects(bb)
+	case "repo_slug", "repo":
+		if projectKey != "" {
+			candidates, err = p.repositories(bb, projectKey)
+		}
+	case "ref", "branch", "from_branch", "to_branch":
+		if projectKey != "" && repoSlug != "" {
+			candidates, err = p.branches(bb, projectKey, repoSlug)
+		}
+	case "pull_request_id", "id":
+		if projectKey != "" && repoSlug != "" {
+			candidates, err = p.pullRequests(bb, projectKey, repoSlug)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return matchCompletions(candidates, argument.Value), nil
+}
+
+// contextArgument returns the first of the named arguments that is already filled in
+func contextArgument(completeContext mcp.CompleteContext, names ...string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(completeContext.Arguments[name]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (p *CompletionProvider) projects(bb *bitbucket.Server) ([]completionCandidate, error) {
+	return p.cached(bb, "projects", func() ([]completionCandidate, error) {
+		projects, err := bb.GetProjects(completionListLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list projects: %w", err)
+		}
+		candidates := make([]completionCandidate, 0, len(projects))
+		for _, project := range projects {
+			candidates = append(candidates, completionCandidate{value: project.Key, keywords: project.Name})
+		}
+		return candidates, nil
+	})
+}
+
+func (p *CompletionProvider) repositories(bb *bitbucket.Server, projectKey string) ([]completionCandidate, error) {
+	return p.cached(bb, "repos:"+projectKey, func() ([]completionCandidate, error) {
+		repos, err := bb.GetRepos(projectKey, 100, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %w", err)
+		}
+		candidates := make([]completionCandidate, 0, len(repos))
+		for _, repo := range repos {
+			candidates = append(candidates, completionCandidate{value: repo.Slug, keywords: repo.Name})
+		}
+		return candidates, nil
+	})
+}
+
+func (p *CompletionProvider) branches(bb *bitbucket.Server, projectKey, repoSlug string) ([]completionCandidate, error) {
+	return p.cached(bb, "branches:"+projectKey+"/"+repoSlug, func() ([]completionCandidate, error) {
+		branches, err := bb.GetBranches(projectKey, repoSlug, "", completionListLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list branches: %w", err)
+		}
+		candidates := make([]completionCandidate, 0, len(branches))
+		for _, branch := range branches {
+			candidates = append(candidates, completionCandidate{value: branch.DisplayID})
+		}
+		return candidates, nil
+	})
+}
+
+func (p *CompletionProvider) pullRequests(bb *bitbucket.Server, projectKey, repoSlug string) ([]completionCandidate, error) {
+	return p.cached(bb, "pull-requests:"+projectKey+"/"+repoSlug, func() ([]completionCandidate, error) {
+		prs, err := bb.GetPullRequests(projectKey, repoSlug, bitbucket.PullRequestListOptions{
+			State: "OPEN",
+			Order: "NEWEST",
+			Limit: completionListLimit,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pull requests: %w", err)
+		}
+		candidates := make([]completionCandidate, 0, len(prs))
+		for _, pr := range prs {
+			candidates = append(candidates, completionCandidate{value: strconv.Itoa(pr.ID), keywords: pr.Title})
+		}
+		return candidates, nil
+	})
+}
+
+// cached returns the listing stored under key for the client, fetching it when missing or
+// expired. Failed fetches are not cached.
+func (p *CompletionProvider) cached(bb *bitbucket.Server, key string, fetch func() ([]completionCandidate, error)) ([]completionCandidate, error) {
+	key = fmt.Sprintf("%p:%s", bb, key)
+
+	p.mu.Lock()
+	entry, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.candidates, nil
+	}
+
+	candidates, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	// Listings of users who stopped typing would otherwise pile up
+	for k, entry := range p.cache {
+		if now.After(entry.expires) {
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = completionCacheEntry{candidates: candidates, expires: now.Add(completionCacheTTL)}
+	p.mu.Unlock()
+	return candidates, nil
+}
+
+// matchCompletions returns the candidates whose value starts with the typed text, followed by
+// those whose value or keywords contain it, ignoring case. Listing order is kept, so pull
+// requests and branches stay newest first.
+func matchCompletions(candidates []completionCandidate, typed string) *mcp.Completion {
+	typed = strings.ToLower(strings.TrimSpace(typed))
+
+	var prefixed, contained []string
+	for _, candidate := range candidates {
+		value := strings.ToLower(candidate.value)
+		switch {
+		case strings.HasPrefix(value, typed):
+			prefixed = append(prefixed, candidate.value)
+		case strings.Contains(value, typed) || strings.Contains(strings.ToLower(candidate.keywords), typed):
+			contained = append(contained, candidate.value)
+		}
+	}
+
+	values := append(prefixed, contained...)
+	total := len(values)
+	if total > maxCompletionValues {
+		values = values[:maxCompletionValues]
+	}
+	if values == nil {
+		values = []string{}
+	}
+
+	return &mcp.Completion{
+		Values:  values,
+		Total:   total,
+		HasMore: total > len(values),
+	}
+}
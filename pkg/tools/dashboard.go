@@ -0,0 +1,279 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// buildStateConcurrency bounds how many build states my_pull_requests looks up at the same time
+const buildStateConcurrency = 8
+
+// dashboardRow is one pull request in the my_pull_requests table
+type dashboardRow struct {
+	Repository string `json:"repository"`
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	State      string `json:"state"`
+	Age        string `json:"age"`
+	Approvals  string `json:"approvals"`
+	NeedsWork  int    `json:"needsWork"`
+	Build      string `json:"build,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+func RegisterMyPullRequests(s *server.MCPServer, bb *bitbucket.Server) {
+	myPRsTool := mcp.NewTool("my_pull_requests",
+		mcp.WithDescription("List pull requests across all repositories where the current user is author, reviewer or participant, as a compact table of repository, title, age, approvals and build state"),
+		mcp.WithString("role",
+			mcp.Description("Only pull requests where the current user has this role"),
+			mcp.Enum("AUTHOR", "REVIEWER", "PARTICIPANT"),
+		),
+		mcp.WithString("state",
+			mcp.Description("Filter by state (default: OPEN)"),
+			mcp.Enum("OPEN", "MERGED", "DECLINED", "ALL"),
+			mcp.DefaultString("OPEN"),
+		),
+		mcp.WithString("participant_status",
+			mcp.Description("Comma-separated review statuses of the current user to include: UNAPPROVED, NEEDS_WORK, APPROVED"),
+		),
+		mcp.WithBoolean("needs_my_review",
+			mcp.Description("Only open pull requests waiting for the current user's review (the Bitbucket inbox). Cannot be combined with role, state, participant_status or order"),
+		),
+		mcp.WithString("order",
+			mcp.Description("Sort order (default: NEWEST)"),
+			mcp.Enum("NEWEST", "OLDEST"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of pull requests to return (1-1000, default: 25)"),
+		),
+		mcp.WithBoolean("include_build_status",
+			mcp.Description("Look up the build state of each pull request's latest commit (default: true)"),
+			mcp.DefaultBool(true),
+		),
+		withFormat(formatMarkdown),
+		withFields(),
+		withOutputSchema[valuesResult[dashboardRow]](),
+	)
+
+	s.AddTool(myPRsTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		role := args.Enum("role", "", "AUTHOR", "REVIEWER", "PARTICIPANT")
+		state := args.Enum("state", "", "OPEN", "MERGED", "DECLINED", "ALL")
+		order := args.Enum("order", "", "NEWEST", "OLDEST")
+		participantStatus := args.String("participant_status")
+		needsMyReview := args.Bool("needs_my_review", false)
+		includeBuildStatus := args.Bool("include_build_status", true)
+		limit := args.Int("limit", 25, 1, 1000)
+		output := args.Output(formatMarkdown)
+
+		var statuses []string
+		for _, status := range strings.Split(participantStatus, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if status == "" {
+				continue
+			}
+			if status != "UNAPPROVED" && status != "NEEDS_WORK" && status != "APPROVED" {
+				args.problemf("participant_status must only contain UNAPPROVED, NEEDS_WORK or APPROVED, got %q", status)
+			}
+			statuses = append(statuses, status)
+		}
+		if needsMyReview && (role != "" || (state != "" && state != "OPEN") || len(statuses) > 0 || order != "") {
+			args.problemf("needs_my_review cannot be combined with role, state, participant_status or order; the inbox only lists open pull requests to review")
+		}
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		var prs []bitbucket.PullRequest
+		var err error
+		if needsMyReview {
+			prs, err = bb.GetInboxPullRequests(limit)
+		} else {
+			if state == "" {
+				state = "OPEN"
+			}
+			prs, err = bb.GetDashboardPullRequests(bitbucket.DashboardOptions{
+				State:             state,
+				Role:              role,
+				ParticipantStatus: statuses,
+				Order:             order,
+				Limit:             limit,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull requests: %w", err)
+		}
+
+		var buildStates map[string]string
+		if includeBuildStatus {
+			buildStates = fetchBuildStates(ctx, bb, prs)
+		}
+
+		rows := make([]dashboardRow, 0, len(prs))
+		now := time.Now()
+		for _, pr := range prs {
+			row := newDashboardRow(pr, now)
+			if includeBuildStatus && pr.FromRef.LatestCommit != "" {
+				row.Build = buildStates[pr.FromRef.LatestCommit]
+			}
+			rows = append(rows, row)
+		}
+
+		if useCustomMarkdown(output) {
+			return structuredResult(rows, fmt.Sprintf("%d pull requests\n\n%s", len(rows), renderDashboardTable(rows, includeBuildStatus)), nil)
+		}
+
+		return formatResult(rows, output)
+	}))
+}
+
+// fetchBuildStates looks up the build state of each pull request's latest commit, querying at
+// most buildStateConcurrency commits at a time. Commits whose stats cannot be read are UNKNOWN.
+func fetchBuildStates(ctx context.Context, bb *bitbucket.Server, prs []bitbucket.PullRequest) map[string]string {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	states := map[string]string{}
+	sem := make(chan struct{}, buildStateConcurrency)
+
+	for _, pr := range prs {
+		commit := pr.FromRef.LatestCommit
+		if commit == "" {
+			continue
+		}
+		mu.Lock()
+		_, seen := states[commit]
+		if !seen {
+			states[commit] = "UNKNOWN"
+		}
+		mu.Unlock()
+		if seen {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stats, err := bb.GetCommitBuildStats(commit)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			states[commit] = stats.State()
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return states
+}
+
+func newDashboardRow(pr bitbucket.PullRequest, now time.Time) dashboardRow {
+	approved, needsWork := 0, 0
+	for _, reviewer := range pr.Reviewers {
+		switch {
+		case reviewer.Approved || reviewer.Status == "APPROVED":
+			approved++
+		case reviewer.Status == "NEEDS_WORK":
+			needsWork++
+		}
+	}
+
+	return dashboardRow{
+		Repository: pr.ToRef.Repository.Project.Key + "/" + pr.ToRef.Repository.Slug,
+		ID:         pr.ID,
+		Title:      pr.Title,
+		Author:     pr.Author.User.DisplayName,
+		State:      pr.State,
+		Age:        formatAge(now.Sub(time.UnixMilli(pr.CreatedDate))),
+		Approvals:  fmt.Sprintf("%d/%d", approved, len(pr.Reviewers)),
+		NeedsWork:  needsWork,
+		URL:        selfLink(pr.Links),
+	}
+}
+
+func renderDashboardTable(rows []dashboardRow, includeBuild bool) string {
+	if len(rows) == 0 {
+		return "No pull requests found.\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Repository | PR | Title | Author | Age | Approvals |")
+	if includeBuild {
+		sb.WriteString(" Build |")
+	}
+	sb.WriteString("\n|---|---|---|---|---|---|")
+	if includeBuild {
+		sb.WriteString("---|")
+	}
+	sb.WriteString("\n")
+
+	for _, row := range rows {
+		approvals := row.Approvals
+		if row.NeedsWork > 0 {
+			approvals += fmt.Sprintf(" (%d needs work)", row.NeedsWork)
+		}
+		title := escapeTableCell(row.Title)
+		if row.State != "" && row.State != "OPEN" {
+			title += " [" + row.State + "]"
+		}
+		fmt.Fprintf(&sb, "| %s | #%d | %s | %s | %s | %s |", row.Repository, row.ID, title, escapeTableCell(row.Author), row.Age, approvals)
+		if includeBuild {
+			fmt.Fprintf(&sb, " %s |", row.Build)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// formatAge renders a duration as a short age like 45m, 3h, 2d or 6w
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d < 14*24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	default:
+		return fmt.Sprintf("%dw", int(d.Hours()/(24*7)))
+	}
+}
+
+func escapeTableCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+// selfLink returns the web URL from a Bitbucket links object
+func selfLink(links map[string]interface{}) string {
+	self, ok := links["self"].([]interface{})
+	if !ok || len(self) == 0 {
+		return ""
+	}
+	link, ok := self[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	href, _ := link["href"].(string)
+	return href
+}
@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+)
+
+// generatedFilePatterns match lockfiles, vendored dependencies and generated code that are
+// skipped by get_pull_request_diff unless skip_generated is false or the file is asked for by path
+var generatedFilePatterns = []string{
+	"**/vendor/**",
+	"**/node_modules/**",
+	"**/third_party/**",
+	"**/dist/**",
+	"go.sum",
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Cargo.lock",
+	"Gemfile.lock",
+	"Pipfile.lock",
+	"poetry.lock",
+	"composer.lock",
+	"*.min.js",
+	"*.min.css",
+	"*.map",
+	"*.pb.go",
+	"*_generated.go",
+	"*.gen.go",
+	"zz_generated*.go",
+	"*.designer.cs",
+	"*.snap",
+}
+
+// diffPageOptions controls which files of a diff are rendered and how much fits in one page
+type diffPageOptions struct {
+	Path          string
+	Include       []string
+	Exclude       []string
+	SkipGenerated bool
+	MaxBytes      int
+	MaxLines      int
+	Cursor        string
+}
+
+type skippedDiffFile struct {
+	diff   *bitbucket.Diff
+	reason string
+}
+
+// renderDiffPage renders the selected files of a diff as unified diff text, starting at the cursor
+// and stopping once the byte or line budget is used up. At least one hunk is always rendered so
+// that paging makes progress.
+func renderDiffPage(diff *bitbucket.PullRequestDiff, opts diffPageOptions) (string, error) {
+	var selected []*bitbucket.Diff
+	var skipped []skippedDiffFile
+	for i := range diff.Diffs {
+		file := &diff.Diffs[i]
+		switch {
+		case opts.Path != "":
+			if file.Path() != opts.Path && file.SourcePath() != opts.Path {
+				continue
+			}
+		case len(opts.Include) > 0 && !matchesAnyGlob(opts.Include, file):
+			continue
+		case matchesAnyGlob(opts.Exclude, file):
+			continue
+		case opts.SkipGenerated && matchesAnyGlob(generatedFilePatterns, file):
+			skipped = append(skipped, skippedDiffFile{diff: file, reason: "generated, vendored or lock file"})
+			continue
+		}
+		selected = append(selected, file)
+	}
+
+	if opts.Path != "" && len(selected) == 0 {
+		return "", toolErrorf("file %s is not changed in the pull request", opts.Path)
+	}
+
+	startFile, startHunk := 0, 0
+	if opts.Cursor != "" {
+		_, err := fmt.Sscanf(opts.Cursor, "%d:%d", &startFile, &startHunk)
+		if err != nil || startFile < 0 || startHunk < 0 || startFile > len(selected) || !validCursorHunk(selected, startFile, startHunk) {
+			return "", toolErrorf("invalid cursor %q: pass the cursor returned by the previous call unchanged, with the same filters", opts.Cursor)
+		}
+	}
+
+	var sb strings.Builder
+	if opts.Cursor == "" {
+		writeDiffSummary(&sb, diff, selected, skipped)
+	}
+
+	var body strings.Builder
+	lines := 0
+	rendered := 0
+	nextCursor := ""
+
+files:
+	for fileIndex := startFile; fileIndex < len(selected); fileIndex++ {
+		file := selected[fileIndex]
+		hunkIndex := 0
+		if fileIndex == startFile {
+			hunkIndex = startHunk
+		}
+
+		// The file header is written together with the first hunk so it never ends a page on its own
+		pending := diffFileHeader(file, hunkIndex > 0)
+		if file.Binary {
+			pending += "Binary file changed\n"
+		}
+
+		for ; hunkIndex < len(file.Hunks) || pending != ""; hunkIndex++ {
+			chunk := pending
+			if hunkIndex < len(file.Hunks) {
+				chunk += renderHunk(file.Hunks[hunkIndex])
+			}
+			chunkLines := strings.Count(chunk, "\n")
+			if rendered > 0 && overBudget(body.Len()+len(chunk), lines+chunkLines, opts) {
+				nextCursor = fmt.Sprintf("%d:%d", fileIndex, hunkIndex)
+				break files
+			}
+			body.WriteString(chunk)
+			lines += chunkLines
+			rendered++
+			pending = ""
+		}
+
+		if file.Truncated {
+			body.WriteString("(diff truncated by Bitbucket)\n")
+		}
+		body.WriteString("\n")
+	}
+
+	if body.Len() == 0 && len(selected) > 0 {
+		sb.WriteString("No more files to show.\n")
+	}
+	sb.WriteString(body.String())
+
+	if nextCursor != "" {
+		fmt.Fprintf(&sb, "More diff remains. Call again with cursor \"%s\" and the same filters to continue.\n", nextCursor)
+	}
+	if diff.Truncated {
+		sb.WriteString("Note: Bitbucket truncated this diff; use path to fetch individual files.\n")
+	}
+
+	return sb.String(), nil
+}
+
+// validCursorHunk reports whether the hunk index of a cursor points into its file. Hunk 0 is
+// always accepted, it also stands for a file without hunks and for the end past the last file.
+func validCursorHunk(selected []*bitbucket.Diff, fileIndex, hunkIndex int) bool {
+	if hunkIndex == 0 {
+		return true
+	}
+	return fileIndex < len(selected) && hunkIndex < len(selected[fileIndex].Hunks)
+}
+
+// writeDiffSummary writes the per-file stats header shown on the first page
+func writeDiffSummary(sb *strings.Builder, diff *bitbucket.PullRequestDiff, selected []*bitbucket.Diff, skipped []skippedDiffFile) {
+	totalAdded, totalRemoved := 0, 0
+	for i := range diff.Diffs {
+		added, removed := diff.Diffs[i].Stats()
+		totalAdded += added
+		totalRemoved += removed
+	}
+	fmt.Fprintf(sb, "%d files changed, +%d -%d. Showing %d files:\n", len(diff.Diffs), totalAdded, totalRemoved, len(selected))
+
+	for _, file := range selected {
+		added, removed := file.Stats()
+		fmt.Fprintf(sb, "  %s %s +%d -%d\n", file.ChangeType(), diffDisplayPath(file), added, removed)
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintf(sb, "Skipped %d files (set skip_generated=false or pass path to include them):\n", len(skipped))
+		for _, skip := range skipped {
+			added, removed := skip.diff.Stats()
+			fmt.Fprintf(sb, "  %s +%d -%d (%s)\n", diffDisplayPath(skip.diff), added, removed, skip.reason)
+		}
+	}
+
+	if excluded := len(diff.Diffs) - len(selected) - len(skipped); excluded > 0 {
+		fmt.Fprintf(sb, "Excluded %d files by path, include or exclude filters.\n", excluded)
+	}
+
+	sb.WriteString("\n")
+}
+
+func diffFileHeader(file *bitbucket.Diff, continued bool) string {
+	src, dst := "/dev/null", "/dev/null"
+	if file.Source != nil {
+		src = "a/" + file.SourcePath()
+	}
+	if file.Destination != nil {
+		dst = "b/" + file.Path()
+	}
+
+	added, removed := file.Stats()
+	title := fmt.Sprintf("### %s (%s, +%d -%d)", diffDisplayPath(file), file.ChangeType(), added, removed)
+	if continued {
+		title += " continued"
+	}
+	return fmt.Sprintf("%s\n--- %s\n+++ %s\n", title, src, dst)
+}
+
+func renderHunk(hunk bitbucket.Hunk) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@", hunk.SourceLine, hunk.SourceSpan, hunk.DestinationLine, hunk.DestinationSpan)
+	if hunk.Context != "" {
+		sb.WriteString(" " + hunk.Context)
+	}
+	sb.WriteString("\n")
+
+	for _, segment := range hunk.Segments {
+		prefix := " "
+		switch segment.Type {
+		case "ADDED":
+			prefix = "+"
+		case "REMOVED":
+			prefix = "-"
+		}
+		for _, line := range segment.Lines {
+			sb.WriteString(prefix + line.Line + "\n")
+		}
+	}
+	if hunk.Truncated {
+		sb.WriteString("(hunk truncated by Bitbucket)\n")
+	}
+	return sb.String()
+}
+
+func overBudget(bytes, lines int, opts diffPageOptions) bool {
+	return (opts.MaxBytes > 0 && bytes > opts.MaxBytes) || (opts.MaxLines > 0 && lines > opts.MaxLines)
+}
+
+func diffDisplayPath(file *bitbucket.Diff) string {
+	if file.ChangeType() == "RENAMED" {
+		return file.SourcePath() + " -> " + file.Path()
+	}
+	return file.Path()
+}
+
+func matchesAnyGlob(patterns []string, file *bitbucket.Diff) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, file.Path()) || (file.SourcePath() != "" && matchGlob(pattern, file.SourcePath())) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a path against a glob supporting *, ? and **. Like .gitignore, a pattern
+// without a slash matches the file name in any directory, other patterns match from the repository root.
+func matchGlob(pattern, filePath string) bool {
+	pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "/")
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(filePath))
+		return matched
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			re.WriteString("[^/]*")
+		case pattern[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
+	}
+	re.WriteString("$")
+
+	matched, err := regexp.MatchString(re.String(), filePath)
+	return err == nil && matched
+}
+
+// splitGlobs splits a comma-separated list of glob patterns
+func splitGlobs(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
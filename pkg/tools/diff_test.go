@@ -0,0 +1,110 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"bbcli/pkg/bitbucket"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/tools/diff.go", true},
+		{"go.sum", "tools/go.sum", true},
+		{"*.go", "main.go.orig", false},
+		{"pkg/*.go", "pkg/main.go", true},
+		{"pkg/*.go", "pkg/tools/diff.go", false},
+		{"pkg/**/*.go", "pkg/main.go", true},
+		{"pkg/**/*.go", "pkg/tools/diff.go", true},
+		{"pkg/**", "pkg/tools/diff.go", true},
+		{"**/vendor/**", "vendor/a/b.go", true},
+		{"**/vendor/**", "src/vendor/a.go", true},
+		{"**/vendor/**", "src/vendored/a.go", false},
+		{"/cmd/?.go", "cmd/a.go", true},
+		{"cmd/?.go", "cmd/ab.go", false},
+		{"docs/a+b.md", "docs/a+b.md", true},
+		{"docs/a+b.md", "docs/aab.md", false},
+		{"  ", "main.go", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+// testPagedDiff has a modified file per argument with that many hunks, each adding one line
+func testPagedDiff(hunks ...int) *bitbucket.PullRequestDiff {
+	diff := &bitbucket.PullRequestDiff{}
+	for file, count := range hunks {
+		path := &bitbucket.DiffPath{ToString: fmt.Sprintf("file%d.go", file)}
+		d := bitbucket.Diff{Source: path, Destination: path}
+		for hunk := 0; hunk < count; hunk++ {
+			line := 10 * (hunk + 1)
+			d.Hunks = append(d.Hunks, bitbucket.Hunk{
+				SourceLine: line, DestinationLine: line, DestinationSpan: 1,
+				Segments: []bitbucket.Segment{{Type: "ADDED", Lines: []bitbucket.Line{{Destination: line, Line: fmt.Sprintf("file %d hunk %d", file, hunk)}}}},
+			})
+		}
+		diff.Diffs = append(diff.Diffs, d)
+	}
+	return diff
+}
+
+var cursorPattern = regexp.MustCompile(`cursor "([^"]*)"`)
+
+func TestRenderDiffPageCursorRoundTrip(t *testing.T) {
+	for _, maxLines := range []int{1, 6, 9, 20} {
+		t.Run(fmt.Sprintf("max_lines=%d", maxLines), func(t *testing.T) {
+			diff := testPagedDiff(3, 1, 2)
+			var seen []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > 20 {
+					t.Fatal("paging does not end")
+				}
+				page, err := renderDiffPage(diff, diffPageOptions{MaxLines: maxLines, Cursor: cursor})
+				if err != nil {
+					t.Fatalf("page %d with cursor %q: %v", pages+1, cursor, err)
+				}
+				for _, line := range strings.Split(page, "\n") {
+					if strings.HasPrefix(line, "+file ") {
+						seen = append(seen, strings.TrimPrefix(line, "+"))
+					}
+				}
+				match := cursorPattern.FindStringSubmatch(page)
+				if match == nil {
+					break
+				}
+				cursor = match[1]
+			}
+
+			want := []string{"file 0 hunk 0", "file 0 hunk 1", "file 0 hunk 2", "file 1 hunk 0", "file 2 hunk 0", "file 2 hunk 1"}
+			if strings.Join(seen, ",") != strings.Join(want, ",") {
+				t.Errorf("hunks rendered across pages = %q, want each once in order %q", seen, want)
+			}
+		})
+	}
+}
+
+func TestRenderDiffPageRejectsInvalidCursor(t *testing.T) {
+	for _, cursor := range []string{"x", "-1:0", "0:-1", "4:0", "3:1", "1:1", "0:3"} {
+		_, err := renderDiffPage(testPagedDiff(3, 1, 2), diffPageOptions{Cursor: cursor})
+		if err == nil || !strings.Contains(err.Error(), "invalid cursor") {
+			t.Errorf("cursor %q: error = %v, want an invalid cursor error", cursor, err)
+		}
+	}
+	for _, cursor := range []string{"0:2", "2:1", "3:0"} {
+		if _, err := renderDiffPage(testPagedDiff(3, 1, 2), diffPageOptions{Cursor: cursor}); err != nil {
+			t.Errorf("cursor %q: %v", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// toolError is a failure the caller can fix, such as an invalid argument. It is returned to the
+// model as an error result instead of a protocol error.
+type toolError struct {
+	message string
+}
+
+func (e *toolError) Error() string {
+	return e.message
+}
+
+func toolErrorf(format string, args ...interface{}) error {
+	return &toolError{message: fmt.Sprintf(format, args...)}
+}
+
+// handle wraps a tool handler so that invalid arguments, read-only refusals and Bitbucket API
+// errors become error results with guidance the model can act on. Anything else, such as a
+// network failure or a response that cannot be decoded, stays a Go error.
+func handle(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := handler(ctx, request)
+		if err == nil {
+			return result, nil
+		}
+
+		var tErr *toolError
+		if errors.As(err, &tErr) {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if hint, ok := errorHint(err); ok {
+			return mcp.NewToolResultError(err.Error() + "\n" + hint), nil
+		}
+		return nil, err
+	}
+}
+
+// errorHint tells the model what to do about a read-only refusal or Bitbucket API error, and
+// reports false for errors that are server faults
+func errorHint(err error) (string, bool) {
+	if errors.Is(err, bitbucket.ErrReadOnly) {
+		return "Do not retry: changes are disabled. Ask the user to make the change or to turn off read-only mode.", true
+	}
+	if errors.Is(err, bitbucket.ErrNoCredentials) {
+		return "Do not retry: the server has no shared Bitbucket credentials. Ask the user to configure their MCP client to send their Bitbucket personal access token in the X-Bitbucket-Token header.", true
+	}
+	if errors.Is(err, bitbucket.ErrUserTokenRequired) {
+		return "Do not retry: this server only calls Bitbucket with the user's own token. Ask the user to configure their MCP client to send their Bitbucket personal access token in the X-Bitbucket-Token header.", true
+	}
+	if errors.Is(err, bitbucket.ErrTokenNotForInstance) {
+		return "Do not retry: the user's Bitbucket tokens are only sent to the instances they were given for. Ask the user to add an X-Bitbucket-Token-<instance> header with their token for this instance to their MCP client configuration.", true
+	}
+	if errors.Is(err, bitbucket.ErrCredentialSource) {
+		return "Do not retry: the server cannot read its Bitbucket credentials. Ask the user to check BITBUCKET_TOKEN_FILE, BITBUCKET_TOKEN_COMMAND or their netrc file.", true
+	}
+	if errors.Is(err, bitbucket.ErrNotLoggedIn) {
+		return "Do not retry: the server has no valid OAuth token. Ask the user to run `bbcli login` on the machine running the server.", true
+	}
+
+	var apiErr *bitbucket.APIError
+	if !errors.As(err, &apiErr) {
+		return "", false
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusBadRequest:
+		return "Bitbucket rejected the request. Correct the arguments according to the message and call the tool again.", true
+	case apiErr.StatusCode == http.StatusUnauthorized:
+		return "Bitbucket rejected the credentials. Do not retry; ask the user to check their token in the X-Bitbucket-Token header, or BITBUCKET_TOKEN, or BITBUCKET_USERNAME and BITBUCKET_PASSWORD.", true
+	case apiErr.StatusCode == http.StatusForbidden:
+		return "The configured user lacks permission for this operation. Do not retry; ask the user to grant access or make the change themselves.", true
+	case apiErr.StatusCode == http.StatusNotFound:
+		return "Check project_key, repo_slug and any IDs; use get_repos and list_pull_requests to find valid values.", true
+	case apiErr.StatusCode == http.StatusConflict:
+		return "The object has changed or is in the wrong state. Fetch it again and retry with its current version or state.", true
+	case apiErr.StatusCode >= 500:
+		return "Bitbucket failed internally; this is not caused by the arguments. Retry later.", true
+	default:
+		return "Check the arguments against the message before retrying.", true
+	}
+}
@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// localTools do not call Bitbucket and so take no instance argument
+var localTools = map[string]bool{
+	"hello_world": true,
+}
+
+// RegisterInstanceArgument adds an optional instance argument to every registered Bitbucket tool,
+// naming the Bitbucket instance (configuration profile) the call is made against. Calls without it
+// go to defaultInstance. The handlers need no changes: the chosen client is put in the context,
+// where bitbucket.Server.ForContext picks it up. Resources, resource templates, prompts,
+// completions and subscriptions have no such argument and always use defaultInstance.
+func RegisterInstanceArgument(s *server.MCPServer, instances map[string]*bitbucket.Server, defaultInstance string) {
+	names := make([]string, 0, len(instances))
+	for name := range instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	property := map[string]any{
+		"type":        "string",
+		"description": fmt.Sprintf("Bitbucket instance to use: %s (defaults to %s). Resources, prompts and subscriptions always use %s", strings.Join(names, ", "), defaultInstance, defaultInstance),
+		"enum":        names,
+	}
+
+	var wrapped []server.ServerTool
+	for _, tool := range s.ListTools() {
+		if localTools[tool.Tool.Name] {
+			continue
+		}
+		properties := make(map[string]any, len(tool.Tool.InputSchema.Properties)+1)
+		for name, schema := range tool.Tool.InputSchema.Properties {
+			properties[name] = schema
+		}
+		properties["instance"] = property
+		tool.Tool.InputSchema.Properties = properties
+
+		wrapped = append(wrapped, server.ServerTool{
+			Tool:    tool.Tool,
+			Handler: withInstance(instances, names, tool.Handler),
+		})
+	}
+	s.AddTools(wrapped...)
+}
+
+// withInstance routes a tool call to the instance named by its instance argument
+func withInstance(instances map[string]*bitbucket.Server, names []string, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := parseArgs(request)
+		name := strings.TrimSpace(args.String("instance"))
+		if err := args.Err(); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if name == "" {
+			return next(ctx, request)
+		}
+
+		instance, ok := instances[name]
+		if !ok {
+			return mcp.NewToolResultError(fmt.Sprintf("unknown instance %q: use one of %s", name, strings.Join(names, ", "))), nil
+		}
+		return next(bitbucket.ContextWithInstance(ctx, instance), request)
+	}
+}
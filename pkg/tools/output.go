@@ -0,0 +1,535 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Output formats shared by the tools that return Bitbucket objects. json is the Bitbucket
+// response as is, compact drops links and other noise and flattens users, repositories and
+// refs, markdown renders the compact form as a table or list.
+const (
+	formatJSON     = "json"
+	formatCompact  = "compact"
+	formatMarkdown = "markdown"
+)
+
+// noisyKeys are left out of compact and markdown output
+var noisyKeys = map[string]bool{
+	"links":               true,
+	"permittedOperations": true,
+	"properties":          true,
+}
+
+// valuesResult wraps list results, since structured tool output has to be a JSON object
+type valuesResult[T any] struct {
+	Values []T `json:"values"`
+}
+
+// withOutputSchema declares the JSON schema generated from T as the tool's output schema. No field
+// is marked required because Bitbucket leaves out fields depending on version and context; nested
+// types are referenced through $defs since comments nest recursively.
+func withOutputSchema[T any]() mcp.ToolOption {
+	reflector := jsonschema.Reflector{
+		ExpandedStruct:             true,
+		Anonymous:                  true,
+		AllowAdditionalProperties:  true,
+		RequiredFromJSONSchemaTags: true,
+	}
+	var zero T
+	schema := reflector.Reflect(zero)
+	schema.Version = ""
+
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return func(*mcp.Tool) {}
+	}
+	return mcp.WithRawOutputSchema(raw)
+}
+
+// outputOptions holds the format and fields arguments of a tool call
+type outputOptions struct {
+	Format string
+	Fields []string
+}
+
+// withFormat adds the shared format argument to a tool
+func withFormat(defaultFormat string) mcp.ToolOption {
+	return mcp.WithString("format",
+		mcp.Description(fmt.Sprintf("Output format: json (full Bitbucket response), compact (without links and noise) or markdown (default: %s)", defaultFormat)),
+		mcp.Enum(formatJSON, formatCompact, formatMarkdown),
+		mcp.DefaultString(defaultFormat),
+	)
+}
+
+// withFields adds the shared fields argument to a tool
+func withFields() mcp.ToolOption {
+	return mcp.WithString("fields",
+		mcp.Description("Comma-separated fields to return, e.g. id,title,author or toRef.displayId. Applies to each item of a list"),
+	)
+}
+
+// useCustomMarkdown reports whether a tool with a markdown renderer of its own should use it,
+// which is the case for markdown output without a fields selection
+func useCustomMarkdown(opts outputOptions) bool {
+	return opts.Format == formatMarkdown && len(opts.Fields) == 0
+}
+
+// formatResult renders a tool response according to the format and fields arguments. The
+// columns are used for markdown tables when no fields are selected.
+func formatResult(v interface{}, opts outputOptions, columns ...string) (*mcp.CallToolResult, error) {
+	text, err := renderOutput(v, opts, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	return structuredResult(v, text, opts.Fields)
+}
+
+// structuredResult returns the value as structured content, limited to the selected fields if
+// any, with the rendered text as fallback for clients without support. The format argument does
+// not apply: structured content always keeps Bitbucket's field names and values.
+func structuredResult(v interface{}, text string, fields []string) (*mcp.CallToolResult, error) {
+	structured, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+	structured = dropNulls(structured)
+	if len(fields) > 0 {
+		structured = selectFields(structured, fields)
+	}
+	if reflect.ValueOf(v).Kind() == reflect.Slice {
+		structured = map[string]interface{}{"values": structured}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+		StructuredContent: structured,
+	}, nil
+}
+
+// dropNulls removes null fields, which the generated schemas do not allow, and turns a null list into an empty one
+func dropNulls(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return []interface{}{}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = dropNulls(item)
+		}
+		return value
+	case map[string]interface{}:
+		for key, field := range value {
+			if field == nil {
+				delete(value, key)
+				continue
+			}
+			value[key] = dropNulls(field)
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+func renderOutput(v interface{}, opts outputOptions, columns []string) (string, error) {
+	if opts.Format == formatJSON && len(opts.Fields) == 0 {
+		content, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal response: %v", err)
+		}
+		return string(content), nil
+	}
+
+	generic, err := toGeneric(v)
+	if err != nil {
+		return "", err
+	}
+	if opts.Format != formatJSON {
+		generic = compactValue(generic, false)
+	}
+	if len(opts.Fields) > 0 {
+		generic = selectFields(generic, opts.Fields)
+		columns = opts.Fields
+	}
+
+	switch opts.Format {
+	case formatMarkdown:
+		return renderMarkdown(generic, columns), nil
+	case formatCompact:
+		content, err := json.Marshal(generic)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal response: %v", err)
+		}
+		return string(content), nil
+	default:
+		content, err := json.MarshalIndent(generic, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal response: %v", err)
+		}
+		return string(content), nil
+	}
+}
+
+// toGeneric converts a value to the maps and slices encoding/json produces for it
+func toGeneric(v interface{}) (interface{}, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %v", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(content, &generic); err != nil {
+		return nil, fmt.Errorf("failed to marshal response: %v", err)
+	}
+	return generic, nil
+}
+
+// compactValue strips noise from a generic value. Nested users become "Display Name (username)",
+// nested repositories "PROJECT/slug", timestamps are formatted and empty values dropped.
+func compactValue(v interface{}, nested bool) interface{} {
+	switch value := v.(type) {
+	case []interface{}:
+		items := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			items = append(items, compactValue(item, nested))
+		}
+		return items
+	case map[string]interface{}:
+		switch {
+		case isPullRequestValue(value):
+			return compactPullRequest(value)
+		case nested && isUserValue(value):
+			return userLabel(value)
+		case nested && isRepositoryValue(value):
+			return repositoryLabel(value)
+		case nested && isProjectValue(value):
+			key, _ := value["key"].(string)
+			return key
+		}
+
+		out := map[string]interface{}{}
+		for key, field := range value {
+			if noisyKeys[key] || isEmptyValue(field) {
+				continue
+			}
+			if millis, ok := field.(float64); ok && strings.HasSuffix(key, "Date") {
+				out[key] = formatTimestamp(int64(millis))
+				continue
+			}
+			out[key] = compactValue(field, true)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// compactPullRequest reduces a pull request to the fields needed to reason about it
+func compactPullRequest(pr map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, key := range []string{"id", "version", "title", "description", "state"} {
+		if value, ok := pr[key]; ok && !isEmptyValue(value) {
+			out[key] = value
+		}
+	}
+	if draft, _ := pr["draft"].(bool); draft {
+		out["draft"] = true
+	}
+
+	if author, ok := pr["author"].(map[string]interface{}); ok {
+		if user, ok := author["user"].(map[string]interface{}); ok {
+			out["author"] = userLabel(user)
+		}
+	}
+
+	toRef, _ := pr["toRef"].(map[string]interface{})
+	fromRef, _ := pr["fromRef"].(map[string]interface{})
+	toRepo, _ := toRef["repository"].(map[string]interface{})
+	fromRepo, _ := fromRef["repository"].(map[string]interface{})
+	if toRepo != nil {
+		out["repository"] = repositoryLabel(toRepo)
+	}
+	out["to"] = toRef["displayId"]
+	out["from"] = fromRef["displayId"]
+	if fromRepo != nil && toRepo != nil && repositoryLabel(fromRepo) != repositoryLabel(toRepo) {
+		out["from"] = fmt.Sprintf("%s:%v", repositoryLabel(fromRepo), fromRef["displayId"])
+	}
+	if commit, ok := fromRef["latestCommit"].(string); ok && commit != "" {
+		out["fromCommit"] = commit
+	}
+
+	if created, ok := pr["createdDate"].(float64); ok {
+		out["created"] = formatTimestamp(int64(created))
+		out["age"] = formatAge(time.Since(time.UnixMilli(int64(created))))
+	}
+	if updated, ok := pr["updatedDate"].(float64); ok {
+		out["updated"] = formatTimestamp(int64(updated))
+	}
+
+	reviewers, _ := pr["reviewers"].([]interface{})
+	approved := 0
+	var reviewerLabels []string
+	for _, r := range reviewers {
+		reviewer, _ := r.(map[string]interface{})
+		user, _ := reviewer["user"].(map[string]interface{})
+		status, _ := reviewer["status"].(string)
+		if status == "APPROVED" {
+			approved++
+		}
+		reviewerLabels = append(reviewerLabels, fmt.Sprintf("%s %s", userLabel(user), status))
+	}
+	out["approvals"] = fmt.Sprintf("%d/%d", approved, len(reviewers))
+	if len(reviewerLabels) > 0 {
+		out["reviewers"] = reviewerLabels
+	}
+
+	if properties, ok := pr["properties"].(map[string]interface{}); ok {
+		if count, ok := properties["commentCount"]; ok {
+			out["comments"] = count
+		}
+		if count, ok := properties["openTaskCount"]; ok {
+			out["openTasks"] = count
+		}
+	}
+	return out
+}
+
+func isPullRequestValue(value map[string]interface{}) bool {
+	_, hasFrom := value["fromRef"]
+	_, hasTo := value["toRef"]
+	_, hasTitle := value["title"]
+	return hasFrom && hasTo && hasTitle
+}
+
+func isUserValue(value map[string]interface{}) bool {
+	_, hasSlug := value["slug"]
+	_, hasDisplayName := value["displayName"]
+	_, hasProject := value["project"]
+	return hasSlug && hasDisplayName && !hasProject
+}
+
+func isRepositoryValue(value map[string]interface{}) bool {
+	_, hasSlug := value["slug"]
+	_, hasProject := value["project"].(map[string]interface{})
+	return hasSlug && hasProject
+}
+
+func isProjectValue(value map[string]interface{}) bool {
+	_, hasKey := value["key"].(string)
+	_, hasName := value["name"]
+	_, hasType := value["type"]
+	return hasKey && hasName && hasType
+}
+
+func userLabel(user map[string]interface{}) string {
+	displayName, _ := user["displayName"].(string)
+	name, _ := user["name"].(string)
+	if displayName == "" || displayName == name {
+		return name
+	}
+	if name == "" {
+		return displayName
+	}
+	return fmt.Sprintf("%s (%s)", displayName, name)
+}
+
+func repositoryLabel(repo map[string]interface{}) string {
+	project, _ := repo["project"].(map[string]interface{})
+	key, _ := project["key"].(string)
+	slug, _ := repo["slug"].(string)
+	return key + "/" + slug
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	default:
+		return false
+	}
+}
+
+// selectFields keeps only the given dot-separated paths of an object, or of each object in a list.
+// When none of the fields exist on an object, such as a page of results, they are applied to the
+// objects in its lists instead.
+func selectFields(v interface{}, fields []string) interface{} {
+	switch value := v.(type) {
+	case []interface{}:
+		items := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			items = append(items, selectFields(item, fields))
+		}
+		return items
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for _, field := range fields {
+			if fieldValue, ok := lookupField(value, field); ok {
+				out[field] = fieldValue
+			}
+		}
+		if len(out) > 0 {
+			return out
+		}
+		for key, field := range value {
+			if items, ok := field.([]interface{}); ok && isObjectList(items) {
+				out[key] = selectFields(items, fields)
+			} else {
+				out[key] = field
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func lookupField(value map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = value
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// renderMarkdown renders a list of objects as a table and an object as a bullet list, with
+// nested lists of objects as tables of their own. Tables use the given columns when set.
+func renderMarkdown(v interface{}, columns []string) string {
+	var sb strings.Builder
+	switch value := v.(type) {
+	case []interface{}:
+		writeMarkdownTable(&sb, value, columns)
+	case map[string]interface{}:
+		var tables []string
+		for _, key := range sortedKeys(value) {
+			if items, ok := value[key].([]interface{}); ok && len(items) > 0 && isObjectList(items) {
+				tables = append(tables, key)
+				continue
+			}
+			fmt.Fprintf(&sb, "- **%s**: %s\n", key, markdownCell(value[key]))
+		}
+		for _, key := range tables {
+			fmt.Fprintf(&sb, "\n**%s**\n\n", key)
+			writeMarkdownTable(&sb, value[key].([]interface{}), columns)
+		}
+	default:
+		sb.WriteString(markdownCell(v))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func writeMarkdownTable(sb *strings.Builder, items []interface{}, columns []string) {
+	if len(items) == 0 {
+		sb.WriteString("No results.\n")
+		return
+	}
+	if !isObjectList(items) {
+		for _, item := range items {
+			fmt.Fprintf(sb, "- %s\n", markdownCell(item))
+		}
+		return
+	}
+
+	if len(columns) == 0 {
+		columns = tableColumns(items)
+	}
+
+	sb.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+	sb.WriteString("|" + strings.Repeat("---|", len(columns)) + "\n")
+	for _, item := range items {
+		object := item.(map[string]interface{})
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			if field, ok := lookupField(object, column); ok {
+				cells[i] = markdownCell(field)
+			} else if field, ok := object[column]; ok {
+				cells[i] = markdownCell(field)
+			}
+		}
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+}
+
+// tableColumns lists the keys used by any of the objects, id first, then title, then alphabetically
+func tableColumns(items []interface{}) []string {
+	seen := map[string]bool{}
+	for _, item := range items {
+		for key := range item.(map[string]interface{}) {
+			seen[key] = true
+		}
+	}
+	var columns []string
+	for _, key := range []string{"id", "title"} {
+		if seen[key] {
+			columns = append(columns, key)
+			delete(seen, key)
+		}
+	}
+	return append(columns, sortedKeys(seen)...)
+}
+
+func markdownCell(v interface{}) string {
+	var text string
+	switch value := v.(type) {
+	case nil:
+		text = ""
+	case string:
+		text = value
+	case float64:
+		text = fmt.Sprintf("%v", value)
+	case []interface{}:
+		parts := make([]string, 0, len(value))
+		for _, item := range value {
+			parts = append(parts, markdownCell(item))
+		}
+		text = strings.Join(parts, ", ")
+	default:
+		content, _ := json.Marshal(value)
+		text = string(content)
+	}
+	return escapeTableCell(text)
+}
+
+func isObjectList(items []interface{}) bool {
+	for _, item := range items {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
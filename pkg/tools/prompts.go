@@ -0,0 +1,524 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Prompts embed the diff up to this size; the rest can be paged with get_pull_request_diff
+const promptDiffMaxBytes = 60000
+
+// promptCommitLimit bounds the commits listed in a prompt
+const promptCommitLimit = 100
+
+// promptTarget is the pull request a prompt is about
+type promptTarget struct {
+	projectKey string
+	repoSlug   string
+	id         int
+}
+
+func withPullRequestArguments() []mcp.PromptOption {
+	return []mcp.PromptOption{
+		mcp.WithArgument("project_key",
+			mcp.ArgumentDescription("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithArgument("repo_slug",
+			mcp.RequiredArgument(),
+			mcp.ArgumentDescription("The repository slug"),
+		),
+		mcp.WithArgument("pull_request_id",
+			mcp.RequiredArgument(),
+			mcp.ArgumentDescription("The pull request ID"),
+		),
+	}
+}
+
+// parsePromptTarget reads the pull request arguments. Prompt arguments are always strings.
+func parsePromptTarget(bb *bitbucket.Server, request mcp.GetPromptRequest) (promptTarget, error) {
+	arguments := request.Params.Arguments
+
+	target := promptTarget{
+		projectKey: strings.TrimSpace(arguments["project_key"]),
+		repoSlug:   strings.TrimSpace(arguments["repo_slug"]),
+	}
+	if target.projectKey == "" {
+		target.projectKey = bb.GetDefaultProjectKey()
+	}
+
+	var problems []string
+	if target.projectKey == "" {
+		problems = append(problems, "project_key is required (no BITBUCKET_DEFAULT_PROJECT_KEY is set)")
+	}
+	if target.repoSlug == "" {
+		problems = append(problems, "repo_slug is required")
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(arguments["pull_request_id"]))
+	if err != nil || id <= 0 {
+		problems = append(problems, "pull_request_id must be a positive integer")
+	}
+	target.id = id
+
+	if len(problems) > 0 {
+		return promptTarget{}, fmt.Errorf("invalid arguments: %s", strings.Join(problems, "; "))
+	}
+	return target, nil
+}
+
+func (t promptTarget) String() string {
+	return fmt.Sprintf("%s/%s#%d", t.projectKey, t.repoSlug, t.id)
+}
+
+func promptResult(description, text string) *mcp.GetPromptResult {
+	return mcp.NewGetPromptResult(description, []mcp.PromptMessage{
+		mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text)),
+	})
+}
+
+func RegisterReviewPullRequestPrompt(s *server.MCPServer, bb *bitbucket.Server) {
+	prompt := mcp.NewPrompt("review_pull_request",
+		append([]mcp.PromptOption{
+			mcp.WithPromptDescription("Review a pull request: its description, commits, diff and the threads still open"),
+		}, withPullRequestArguments()...)...,
+	)
+
+	s.AddPrompt(prompt, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		bb := bb.ForContext(ctx)
+		target, err := parsePromptTarget(bb, request)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, err := bb.GetPullRequest(target.projectKey, target.repoSlug, target.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+		commits, err := bb.GetPullRequestCommits(target.projectKey, target.repoSlug, target.id, promptCommitLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request commits: %w", err)
+		}
+		diff, err := bb.GetPullRequestStructuredDiff(target.projectKey, target.repoSlug, target.id, 0, "", "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request diff: %w", err)
+		}
+		threads, err := bb.GetPullRequestCommentThreads(target.projectKey, target.repoSlug, target.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request comments: %w", err)
+		}
+
+		diffText, err := renderDiffPage(diff, diffPageOptions{SkipGenerated: true, MaxBytes: promptDiffMaxBytes})
+		if err != nil {
+			return nil, err
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Review pull request %s.\n\n", target)
+		writePullRequestOverview(&sb, pr)
+		writeCommits(&sb, commits, false)
+		fmt.Fprintf(&sb, "## Diff\n\n%s\n", diffText)
+		writeOpenThreads(&sb, threads)
+		sb.WriteString("## Instructions\n\n")
+		sb.WriteString("Look for bugs, missing error handling, security issues, missing tests and changes that do not match the description. ")
+		sb.WriteString("Do not repeat points already raised in the open threads. ")
+		sb.WriteString("If the diff is cut off, read the rest with get_pull_request_diff before concluding. ")
+		sb.WriteString("Summarize your findings by severity, then offer to post them with submit_review, anchoring inline comments to the lines shown in the diff.\n")
+
+		return promptResult(fmt.Sprintf("Review of %s: %s", target, pr.Title), sb.String()), nil
+	})
+}
+
+func RegisterSummarizeActivityPrompt(s *server.MCPServer, bb *bitbucket.Server) {
+	prompt := mcp.NewPrompt("summarize_activity_since_last_review",
+		append([]mcp.PromptOption{
+			mcp.WithPromptDescription("Summarize what happened on a pull request since you last reviewed or approved it: new commits, comments and status changes"),
+			mcp.WithArgument("since",
+				mcp.ArgumentDescription("Summarize from this time instead of your last review (RFC 3339, YYYY-MM-DD or epoch milliseconds)"),
+			),
+		}, withPullRequestArguments()...)...,
+	)
+
+	s.AddPrompt(prompt, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		bb := bb.ForContext(ctx)
+		target, err := parsePromptTarget(bb, request)
+		if err != nil {
+			return nil, err
+		}
+		var since int64
+		if value := strings.TrimSpace(request.Params.Arguments["since"]); value != "" {
+			if since, err = parseTimestamp(value); err != nil {
+				return nil, fmt.Errorf("invalid arguments: since: %w", err)
+			}
+		}
+
+		user, err := bb.GetCurrentUser()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current user: %w", err)
+		}
+		pr, err := bb.GetPullRequest(target.projectKey, target.repoSlug, target.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+		// Without since the whole history is needed to find the last review
+		activities, err := bb.GetPullRequestActivitySince(target.projectKey, target.repoSlug, target.id, since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request activity: %w", err)
+		}
+
+		// Activities are newest first, so the first one of ours is the last review
+		cutoff := since
+		reviewedWhat := fmt.Sprintf("since %s", formatTimestamp(since))
+		if since == 0 {
+			reviewedWhat = "since the pull request was opened; you have not reviewed it yet"
+			for _, activity := range activities {
+				if activity.User.Slug == user.Slug && isReviewAction(activity.Action) {
+					cutoff = activity.CreatedDate
+					reviewedWhat = fmt.Sprintf("since your last review (%s on %s)", strings.ToLower(activity.Action), formatTimestamp(cutoff))
+					break
+				}
+			}
+		}
+
+		var newActivities []bitbucket.Activity
+		for _, activity := range activities {
+			if activity.CreatedDate < cutoff {
+				break
+			}
+			if activity.User.Slug != user.Slug {
+				newActivities = append(newActivities, activity)
+			}
+		}
+
+		lastReviewedCommit := ""
+		for _, reviewer := range pr.Reviewers {
+			if reviewer.User.Slug == user.Slug {
+				lastReviewedCommit = reviewer.LastReviewedCommit
+			}
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Summarize the activity on pull request %s %s.\n\n", target, reviewedWhat)
+		writePullRequestOverview(&sb, pr)
+
+		sb.WriteString("## Activity by others\n\n")
+		if len(newActivities) == 0 {
+			sb.WriteString("No activity by others.\n\n")
+		}
+		// Oldest first reads as a timeline
+		for i := len(newActivities) - 1; i >= 0; i-- {
+			writeActivity(&sb, newActivities[i])
+		}
+		sb.WriteString("\n")
+
+		if lastReviewedCommit != "" && lastReviewedCommit != pr.FromRef.LatestCommit && since == 0 {
+			diff, err := bb.GetPullRequestStructuredDiff(target.projectKey, target.repoSlug, target.id, 0, "", lastReviewedCommit, "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get changes since the last reviewed commit: %w", err)
+			}
+			diffText, err := renderDiffPage(diff, diffPageOptions{SkipGenerated: true, MaxBytes: promptDiffMaxBytes})
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&sb, "## Changes since the last commit you reviewed (%s)\n\n%s\n", shortCommit(lastReviewedCommit), diffText)
+		}
+
+		sb.WriteString("## Instructions\n\n")
+		sb.WriteString("Summarize briefly what changed: new commits and what they do, questions or requests addressed to me, threads that were resolved or still need my answer, and approval or status changes. ")
+		sb.WriteString("End with what I should do next on this pull request.\n")
+
+		return promptResult(fmt.Sprintf("Activity on %s %s", target, reviewedWhat), sb.String()), nil
+	})
+}
+
+// isReviewAction reports whether an activity counts as reviewing the pull request. Comments do
+// not: replying in one thread says nothing about having looked at the rest.
+func isReviewAction(action string) bool {
+	switch action {
+	case "APPROVED", "UNAPPROVED", "REVIEWED":
+		return true
+	}
+	return false
+}
+
+func RegisterDraftDescriptionPrompt(s *server.MCPServer, bb *bitbucket.Server) {
+	prompt := mcp.NewPrompt("draft_pull_request_description",
+		append([]mcp.PromptOption{
+			mcp.WithPromptDescription("Draft a pull request description from its commits and changed files"),
+		}, withPullRequestArguments()...)...,
+	)
+
+	s.AddPrompt(prompt, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		bb := bb.ForContext(ctx)
+		target, err := parsePromptTarget(bb, request)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, err := bb.GetPullRequest(target.projectKey, target.repoSlug, target.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+		commits, err := bb.GetPullRequestCommits(target.projectKey, target.repoSlug, target.id, promptCommitLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request commits: %w", err)
+		}
+		diff, err := bb.GetPullRequestStructuredDiff(target.projectKey, target.repoSlug, target.id, 0, "", "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request diff: %w", err)
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Draft a description for pull request %s.\n\n", target)
+		writePullRequestOverview(&sb, pr)
+		writeCommits(&sb, commits, true)
+
+		sb.WriteString("## Changed files\n\n")
+		files := make([]*bitbucket.Diff, len(diff.Diffs))
+		for i := range diff.Diffs {
+			files[i] = &diff.Diffs[i]
+		}
+		writeDiffSummary(&sb, diff, files, nil)
+
+		sb.WriteString("## Instructions\n\n")
+		sb.WriteString("Write a description in markdown with a one-paragraph summary of why the change is made, a list of the main changes, and how it was or should be tested. ")
+		sb.WriteString("Base it on the commit messages and changed files; use get_pull_request_diff to check details you are unsure about rather than guessing. ")
+		sb.WriteString("Keep anything useful from the current description.\n")
+
+		return promptResult(fmt.Sprintf("Description draft for %s", target), sb.String()), nil
+	})
+}
+
+func RegisterMergeBlockersPrompt(s *server.MCPServer, bb *bitbucket.Server) {
+	prompt := mcp.NewPrompt("explain_merge_blockers",
+		append([]mcp.PromptOption{
+			mcp.WithPromptDescription("Explain why a pull request cannot be merged yet and what has to happen first"),
+		}, withPullRequestArguments()...)...,
+	)
+
+	s.AddPrompt(prompt, func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		bb := bb.ForContext(ctx)
+		target, err := parsePromptTarget(bb, request)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, err := bb.GetPullRequest(target.projectKey, target.repoSlug, target.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+		settings, err := bb.GetPullRequestSettings(target.projectKey, target.repoSlug)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request settings: %w", err)
+		}
+		builds, err := bb.GetCommitBuildStats(pr.FromRef.LatestCommit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get build status: %w", err)
+		}
+		openTasks, err := openTaskCount(bb, target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request tasks: %w", err)
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Explain what blocks merging pull request %s.\n\n", target)
+		writePullRequestOverview(&sb, pr)
+
+		sb.WriteString("## Merge checks\n\n")
+		if pr.State == "OPEN" {
+			status, err := bb.GetPullRequestMergeStatus(target.projectKey, target.repoSlug, target.id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get merge status: %w", err)
+			}
+			fmt.Fprintf(&sb, "- Can merge: %t\n", status.CanMerge)
+			fmt.Fprintf(&sb, "- Conflicts with %s: %t (outcome %s)\n", pr.ToRef.DisplayID, status.Conflicted, status.Outcome)
+			for _, veto := range status.Vetoes {
+				fmt.Fprintf(&sb, "- Veto: %s", veto.SummaryMessage)
+				if veto.DetailedMessage != "" && veto.DetailedMessage != veto.SummaryMessage {
+					fmt.Fprintf(&sb, ": %s", veto.DetailedMessage)
+				}
+				sb.WriteString("\n")
+			}
+		} else {
+			fmt.Fprintf(&sb, "- The pull request is %s; only open pull requests can be merged\n", pr.State)
+		}
+		fmt.Fprintf(&sb, "- Builds on %s: %s", shortCommit(pr.FromRef.LatestCommit), builds.State())
+		if builds != nil {
+			fmt.Fprintf(&sb, " (%d successful, %d in progress, %d failed)", builds.Successful, builds.InProgress, builds.Failed)
+		}
+		sb.WriteString("\n")
+		fmt.Fprintf(&sb, "- Open tasks: %d\n\n", openTasks)
+
+		sb.WriteString("## Repository merge requirements\n\n")
+		fmt.Fprintf(&sb, "- Required approvers: %d\n", requiredApprovers(settings))
+		fmt.Fprintf(&sb, "- All reviewers must approve: %t\n", settings.RequiredAllApprovers)
+		fmt.Fprintf(&sb, "- All tasks must be resolved: %t\n", settings.RequiredAllTasksComplete)
+		fmt.Fprintf(&sb, "- Required successful builds: %d\n", requiredBuilds(settings))
+		fmt.Fprintf(&sb, "- Needs-work blocks merging: %t\n\n", settings.NeedsWork)
+
+		sb.WriteString("## Instructions\n\n")
+		sb.WriteString("Explain in plain language each reason the pull request cannot be merged, who has to act on it (author, a reviewer or an admin) and what they need to do. ")
+		sb.WriteString("If nothing blocks it, say so and mention the merge strategies that apply.\n")
+
+		return promptResult(fmt.Sprintf("Merge blockers of %s", target), sb.String()), nil
+	})
+}
+
+// openTaskCount counts the open tasks, which are blocker comments on Bitbucket 7.x+
+func openTaskCount(bb *bitbucket.Server, target promptTarget) (int, error) {
+	blockerComments, err := bb.SupportsBlockerComments()
+	if err != nil {
+		return 0, err
+	}
+	if blockerComments {
+		comments, err := bb.GetPullRequestBlockerComments(target.projectKey, target.repoSlug, target.id, "OPEN")
+		if err != nil {
+			return 0, err
+		}
+		return len(comments), nil
+	}
+
+	tasks, err := bb.GetPullRequestTasks(target.projectKey, target.repoSlug, target.id)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, task := range tasks {
+		if task.State == "OPEN" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// requiredApprovers prefers the bundled hook's setting, which older servers report instead
+func requiredApprovers(settings *bitbucket.PullRequestSettings) int {
+	if settings.RequiredApproversHook != nil && settings.RequiredApproversHook.Enable {
+		return settings.RequiredApproversHook.Count
+	}
+	return settings.RequiredApprovers
+}
+
+func requiredBuilds(settings *bitbucket.PullRequestSettings) int {
+	if settings.RequiredBuildsHook != nil && settings.RequiredBuildsHook.Enable {
+		return settings.RequiredBuildsHook.Count
+	}
+	return settings.RequiredSuccessfulBuilds
+}
+
+func writePullRequestOverview(sb *strings.Builder, pr *bitbucket.PullRequest) {
+	fmt.Fprintf(sb, "## Pull request #%d: %s\n\n", pr.ID, pr.Title)
+	fmt.Fprintf(sb, "- State: %s\n", pr.State)
+	fmt.Fprintf(sb, "- Author: %s\n", pr.Author.User.DisplayName)
+	fmt.Fprintf(sb, "- Branches: %s -> %s\n", pr.FromRef.DisplayID, pr.ToRef.DisplayID)
+	fmt.Fprintf(sb, "- Updated: %s\n", formatTimestamp(pr.UpdatedDate))
+	for _, reviewer := range pr.Reviewers {
+		fmt.Fprintf(sb, "- Reviewer: %s (%s)\n", reviewer.User.DisplayName, reviewer.Status)
+	}
+
+	description := strings.TrimSpace(pr.Description)
+	if description == "" {
+		description = "(no description)"
+	}
+	fmt.Fprintf(sb, "\n### Description\n\n%s\n\n", description)
+}
+
+// writeCommits lists commits oldest first, with the full message or just the subject
+func writeCommits(sb *strings.Builder, commits []bitbucket.Commit, fullMessages bool) {
+	fmt.Fprintf(sb, "## Commits (%d)\n\n", len(commits))
+	if len(commits) == promptCommitLimit {
+		fmt.Fprintf(sb, "Only the newest %d commits are shown.\n\n", promptCommitLimit)
+	}
+
+	for i := len(commits) - 1; i >= 0; i-- {
+		commit := commits[i]
+		message := strings.TrimSpace(commit.Message)
+		if !fullMessages {
+			message, _, _ = strings.Cut(message, "\n")
+		}
+		lines := strings.Split(message, "\n")
+		for j := 1; j < len(lines); j++ {
+			if lines[j] != "" {
+				lines[j] = "  " + lines[j]
+			}
+		}
+		fmt.Fprintf(sb, "- %s %s (%s)\n", commit.DisplayID, strings.Join(lines, "\n"), commit.Author.Name)
+	}
+	sb.WriteString("\n")
+}
+
+func writeOpenThreads(sb *strings.Builder, threads []bitbucket.Comment) {
+	var open []bitbucket.Comment
+	for _, thread := range threads {
+		if thread.State != "RESOLVED" && !thread.ThreadResolved {
+			open = append(open, thread)
+		}
+	}
+
+	sb.WriteString("## Open comment threads\n\n")
+	if len(open) == 0 {
+		sb.WriteString("None.\n\n")
+		return
+	}
+	// Bump the file headings below the section heading
+	markdown := renderCommentThreadsMarkdown(groupCommentThreads(open))
+	markdown = strings.ReplaceAll("\n"+markdown, "\n## ", "\n### ")
+	fmt.Fprintf(sb, "%s\n\n", strings.TrimSpace(markdown))
+}
+
+// writeActivity writes one line per activity, with comment text shortened
+func writeActivity(sb *strings.Builder, activity bitbucket.Activity) {
+	fmt.Fprintf(sb, "- %s %s ", formatTimestamp(activity.CreatedDate), activity.User.DisplayName)
+
+	switch activity.Action {
+	case "COMMENTED":
+		verb := "commented"
+		switch activity.CommentAction {
+		case "REPLIED":
+			verb = "replied"
+		case "EDITED":
+			verb = "edited a comment"
+		case "DELETED":
+			verb = "deleted a comment"
+		}
+		sb.WriteString(verb)
+		if anchor := activity.CommentAnchor; anchor != nil && anchor.Path != "" {
+			fmt.Fprintf(sb, " on %s", anchor.Path)
+			if anchor.Line > 0 {
+				fmt.Fprintf(sb, ":%d", anchor.Line)
+			}
+		}
+		if activity.Comment != nil {
+			fmt.Fprintf(sb, ": %s", shortenText(activity.Comment.Text, 500))
+		}
+	case "RESCOPED":
+		added := 0
+		if activity.Added != nil {
+			added = len(activity.Added.Values)
+		}
+		fmt.Fprintf(sb, "updated the branches (%d commits added, now at %s)", added, shortCommit(activity.FromHash))
+	default:
+		sb.WriteString(strings.ToLower(strings.ReplaceAll(activity.Action, "_", " ")))
+	}
+	sb.WriteString("\n")
+}
+
+func shortenText(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "…"
+}
+
+func shortCommit(commitID string) string {
+	if len(commitID) > 11 {
+		return commitID[:11]
+	}
+	return commitID
+}
@@ -0,0 +1,196 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Resource URI templates. Clients can attach these as context directly instead of calling a tool.
+const (
+	repositoriesTemplate    = "bitbucket://{project}/repos"
+	pullRequestTemplate     = "bitbucket://{project}/{repo}/pull-requests/{id}"
+	pullRequestDiffTemplate = "bitbucket://{project}/{repo}/pull-requests/{id}/diff"
+	fileTemplate            = "bitbucket://{project}/{repo}/files/{+path}@{+ref}"
+)
+
+func repositoriesURI(projectKey string) string {
+	return fmt.Sprintf("bitbucket://%s/repos", projectKey)
+}
+
+func RegisterRepositoryResources(s *server.MCPServer, bb *bitbucket.Server) {
+	// The default project's repositories are listed so clients can offer them without a template
+	if projectKey := bb.GetDefaultProjectKey(); projectKey != "" {
+		defaultRepos := mcp.NewResource(repositoriesURI(projectKey), fmt.Sprintf("Repositories in %s", projectKey),
+			mcp.WithResourceDescription("Repositories of the default project (BITBUCKET_DEFAULT_PROJECT_KEY)"),
+			mcp.WithMIMEType("application/json"),
+		)
+
+		s.AddResource(defaultRepos, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return readRepositories(bb.ForContext(ctx), projectKey, request.Params.URI)
+		})
+	}
+
+	reposTemplate := mcp.NewResourceTemplate(repositoriesTemplate, "Repositories",
+		mcp.WithTemplateDescription("Repositories of a project, in compact JSON"),
+		mcp.WithTemplateMIMEType("application/json"),
+	)
+
+	s.AddResourceTemplate(reposTemplate, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		return readRepositories(bb.ForContext(ctx), resourceArg(request, "project"), request.Params.URI)
+	})
+}
+
+func readRepositories(bb *bitbucket.Server, projectKey, uri string) ([]mcp.ResourceContents, error) {
+	repos, err := bb.GetRepos(projectKey, 100, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repositories: %w", err)
+	}
+	if repos == nil {
+		repos = []bitbucket.Repository{}
+	}
+
+	text, err := renderOutput(repos, outputOptions{Format: formatCompact}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{URI: uri, MIMEType: "application/json", Text: text},
+	}, nil
+}
+
+func RegisterPullRequestResources(s *server.MCPServer, bb *bitbucket.Server) {
+	prTemplate := mcp.NewResourceTemplate(pullRequestTemplate, "Pull request",
+		mcp.WithTemplateDescription("A pull request with its branches, reviewers and approvals, in compact JSON"),
+		mcp.WithTemplateMIMEType("application/json"),
+	)
+
+	s.AddResourceTemplate(prTemplate, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		bb := bb.ForContext(ctx)
+		projectKey, repoSlug := resourceArg(request, "project"), resourceArg(request, "repo")
+		pullRequestID, err := resourceID(request, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		pr, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+
+		text, err := renderOutput(pr, outputOptions{Format: formatCompact}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{URI: request.Params.URI, MIMEType: "application/json", Text: text},
+		}, nil
+	})
+
+	diffTemplate := mcp.NewResourceTemplate(pullRequestDiffTemplate, "Pull request diff",
+		mcp.WithTemplateDescription("The complete unified diff of a pull request. Use get_pull_request_diff for filtered or paged diffs"),
+		mcp.WithTemplateMIMEType("text/x-diff"),
+	)
+
+	s.AddResourceTemplate(diffTemplate, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		bb := bb.ForContext(ctx)
+		projectKey, repoSlug := resourceArg(request, "project"), resourceArg(request, "repo")
+		pullRequestID, err := resourceID(request, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		diff, err := bb.GetPullRequestDiff(projectKey, repoSlug, pullRequestID, 0, "", "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request diff: %w", err)
+		}
+
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{URI: request.Params.URI, MIMEType: "text/x-diff", Text: diff},
+		}, nil
+	})
+}
+
+func RegisterFileResources(s *server.MCPServer, bb *bitbucket.Server) {
+	fileTmpl := mcp.NewResourceTemplate(fileTemplate, "Repository file",
+		mcp.WithTemplateDescription(fmt.Sprintf("A file at a branch, tag or commit, e.g. bitbucket://PRJ/repo/files/src/main.go@main. Files over %d bytes are refused", bitbucket.MaxRawFileSize)),
+	)
+
+	s.AddResourceTemplate(fileTmpl, func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		bb := bb.ForContext(ctx)
+		projectKey, repoSlug := resourceArg(request, "project"), resourceArg(request, "repo")
+		filePath, ref := resourceArg(request, "path"), resourceArg(request, "ref")
+
+		file, err := bb.GetRawFile(projectKey, repoSlug, filePath, ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file: %w", err)
+		}
+
+		mimeType := fileMIMEType(filePath, file)
+		if isTextContent(file.Content) {
+			return []mcp.ResourceContents{
+				mcp.TextResourceContents{URI: request.Params.URI, MIMEType: mimeType, Text: string(file.Content)},
+			}, nil
+		}
+
+		return []mcp.ResourceContents{
+			mcp.BlobResourceContents{URI: request.Params.URI, MIMEType: mimeType, Blob: base64.StdEncoding.EncodeToString(file.Content)},
+		}, nil
+	})
+}
+
+// resourceArg returns a variable matched from a resource template
+func resourceArg(request mcp.ReadResourceRequest, name string) string {
+	switch value := request.Params.Arguments[name].(type) {
+	case []string:
+		if len(value) > 0 {
+			return value[0]
+		}
+	case string:
+		return value
+	}
+	return ""
+}
+
+// resourceID returns a template variable that must be a positive integer
+func resourceID(request mcp.ReadResourceRequest, name string) (int, error) {
+	value := resourceArg(request, name)
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s %q in %s: must be a positive integer", name, value, request.Params.URI)
+	}
+	return id, nil
+}
+
+// fileMIMEType prefers the file extension, since Bitbucket serves most files as text/plain or
+// application/octet-stream
+func fileMIMEType(filePath string, file *bitbucket.RawFile) string {
+	mimeType := mime.TypeByExtension(path.Ext(filePath))
+	if mimeType == "" && isTextContent(file.Content) {
+		mimeType = "text/plain"
+	}
+	if mimeType == "" {
+		mimeType = file.ContentType
+	}
+	if mimeType == "" {
+		return "application/octet-stream"
+	}
+	// Leave out parameters such as charset
+	return strings.TrimSpace(strings.Split(mimeType, ";")[0])
+}
+
+func isTextContent(content []byte) bool {
+	return utf8.Valid(content) && !bytes.ContainsRune(content, 0)
+}
@@ -0,0 +1,261 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// reviewComment is one entry of the comments_json argument of submit_review
+type reviewComment struct {
+	Text     string `json:"text"`
+	Path     string `json:"path,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	LineType string `json:"line_type,omitempty"`
+	FileType string `json:"file_type,omitempty"`
+	Severity string `json:"severity,omitempty"`
+
+	anchor *bitbucket.CommentAnchor
+}
+
+// reviewResult is returned by submit_review
+type reviewResult struct {
+	Mode       string `json:"mode"`
+	Verdict    string `json:"verdict"`
+	CommentIDs []int  `json:"commentIds"`
+}
+
+func RegisterSubmitReview(s *server.MCPServer, bb *bitbucket.Server) {
+	submitReviewTool := mcp.NewTool("submit_review",
+		mcp.WithDescription("Submit a complete review in one call: a list of inline and general comments plus a verdict. All anchors are checked against the diff before anything is posted. Uses a pending review (one notification) where the server supports it, otherwise posts comments one by one and deletes them again if any step fails"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithString("verdict",
+			mcp.Required(),
+			mcp.Description("Review outcome: APPROVE, NEEDS_WORK, or COMMENT to only leave comments"),
+			mcp.Enum("APPROVE", "NEEDS_WORK", "COMMENT"),
+		),
+		mcp.WithString("comments_json",
+			mcp.Description("JSON array of comments. Each has text and optionally path and line (inline comment), line_type (ADDED, REMOVED, CONTEXT), file_type (FROM, TO) and severity (NORMAL, BLOCKER). line_type and file_type are worked out from the diff when omitted"),
+		),
+		mcp.WithString("summary",
+			mcp.Description("Optional general comment summarizing the review"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[reviewResult](),
+	)
+
+	s.AddTool(submitReviewTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		verdict := args.RequiredString("verdict")
+		if verdict != "" {
+			verdict = args.Enum("verdict", "", "APPROVE", "NEEDS_WORK", "COMMENT")
+		}
+		summary := args.String("summary")
+
+		var comments []reviewComment
+		if commentsJSON := args.String("comments_json"); commentsJSON != "" {
+			if err := json.Unmarshal([]byte(commentsJSON), &comments); err != nil {
+				args.problemf("comments_json must be a JSON array of comments: %v", err)
+			}
+		}
+		if verdict == "COMMENT" && len(comments) == 0 && strings.TrimSpace(summary) == "" {
+			args.problemf("a COMMENT review needs comments_json or a summary")
+		}
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		pr, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+
+		if err := prepareReviewComments(bb, projectKey, repoSlug, pullRequestID, comments); err != nil {
+			return nil, err
+		}
+
+		pending, drafts, err := bb.SupportsPendingReviews(projectKey, repoSlug, pullRequestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check for pending review support: %w", err)
+		}
+		// Completing the review would publish the user's own drafts under this verdict, so leave
+		// their pending review alone and post the comments one by one
+		if drafts > 0 {
+			pending = false
+		}
+
+		var result *reviewResult
+		if pending {
+			result, err = submitPendingReview(bb, projectKey, repoSlug, pr, comments, summary, verdict)
+		} else {
+			result, err = submitSequentialReview(bb, projectKey, repoSlug, pr, comments, summary, verdict)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return formatResult(result, output)
+	}))
+}
+
+// prepareReviewComments checks every comment and resolves inline anchors against the diff,
+// reporting all problems at once so nothing is posted for a partially valid review
+func prepareReviewComments(bb *bitbucket.Server, projectKey, repoSlug string, pullRequestID int, comments []reviewComment) error {
+	var diff *bitbucket.PullRequestDiff
+	var problems []string
+
+	for i := range comments {
+		comment := &comments[i]
+		if strings.TrimSpace(comment.Text) == "" {
+			problems = append(problems, fmt.Sprintf("comment %d: text is required", i+1))
+			continue
+		}
+		if comment.Severity != "" && comment.Severity != "NORMAL" && comment.Severity != "BLOCKER" {
+			problems = append(problems, fmt.Sprintf("comment %d: severity must be NORMAL or BLOCKER", i+1))
+			continue
+		}
+		if comment.Path == "" {
+			if comment.Line > 0 {
+				problems = append(problems, fmt.Sprintf("comment %d: line requires a path", i+1))
+			}
+			continue
+		}
+
+		if diff == nil {
+			var err error
+			diff, err = bb.GetPullRequestStructuredDiff(projectKey, repoSlug, pullRequestID, 0, "", "", "")
+			if err != nil {
+				return fmt.Errorf("failed to get pull request diff to validate anchors: %w", err)
+			}
+		}
+
+		comment.anchor = &bitbucket.CommentAnchor{
+			Path:     comment.Path,
+			Line:     comment.Line,
+			LineType: comment.LineType,
+			FileType: comment.FileType,
+		}
+		if comment.Line <= 0 {
+			// File comment: the file only has to be part of the diff
+			if file := diff.FindFile(comment.Path); file == nil {
+				problems = append(problems, fmt.Sprintf("comment %d: file %s is not changed in the pull request", i+1, comment.Path))
+			} else {
+				comment.anchor.Path = file.Path()
+			}
+			continue
+		}
+		if err := diff.CompleteAnchor(comment.anchor); err != nil {
+			problems = append(problems, fmt.Sprintf("comment %d: %v", i+1, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return toolErrorf("review not submitted, fix these comments first:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// submitPendingReview adds every comment to a pending review and publishes them together with the
+// verdict. It must only be used when the user has no drafts of their own, which completing the
+// review would publish too. On failure the comments it added are deleted again.
+func submitPendingReview(bb *bitbucket.Server, projectKey, repoSlug string, pr *bitbucket.PullRequest, comments []reviewComment, summary, verdict string) (*reviewResult, error) {
+	result := &reviewResult{Mode: "pending-review", Verdict: verdict, CommentIDs: []int{}}
+	var created []*bitbucket.Comment
+
+	for i, comment := range comments {
+		posted, err := bb.CreatePendingPullRequestComment(projectKey, repoSlug, pr.ID, comment.Text, comment.anchor, comment.Severity)
+		if err != nil {
+			return nil, rollbackComments(bb, projectKey, repoSlug, pr.ID, created, fmt.Errorf("failed to add comment %d to the review: %w", i+1, err))
+		}
+		created = append(created, posted)
+		result.CommentIDs = append(result.CommentIDs, posted.ID)
+	}
+
+	if err := bb.CompletePullRequestReview(projectKey, repoSlug, pr.ID, summary, reviewVerdictStatus(verdict), pr.FromRef.LatestCommit); err != nil {
+		return nil, rollbackComments(bb, projectKey, repoSlug, pr.ID, created, fmt.Errorf("failed to complete review: %w", err))
+	}
+
+	return result, nil
+}
+
+// submitSequentialReview posts the comments one by one for servers without the review API and
+// deletes the ones already posted if a later step fails
+func submitSequentialReview(bb *bitbucket.Server, projectKey, repoSlug string, pr *bitbucket.PullRequest, comments []reviewComment, summary, verdict string) (*reviewResult, error) {
+	result := &reviewResult{Mode: "sequential", Verdict: verdict, CommentIDs: []int{}}
+	var created []*bitbucket.Comment
+
+	if summary != "" {
+		comments = append(comments, reviewComment{Text: summary})
+	}
+
+	for i, comment := range comments {
+		posted, err := bb.CreatePullRequestComment(projectKey, repoSlug, pr.ID, comment.Text, comment.anchor, comment.Severity)
+		if err != nil {
+			return nil, rollbackComments(bb, projectKey, repoSlug, pr.ID, created, fmt.Errorf("failed to post comment %d: %w", i+1, err))
+		}
+		created = append(created, posted)
+		result.CommentIDs = append(result.CommentIDs, posted.ID)
+	}
+
+	if status := reviewVerdictStatus(verdict); status != "" {
+		if _, err := bb.SetReviewStatus(projectKey, repoSlug, pr.ID, status, pr.FromRef.LatestCommit); err != nil {
+			return nil, rollbackComments(bb, projectKey, repoSlug, pr.ID, created, fmt.Errorf("failed to set review status to %s: %w", status, err))
+		}
+	}
+
+	return result, nil
+}
+
+// rollbackComments deletes the comments a failed review already created, newest first. Once
+// something was created the failure is reported as a tool error without the hint for the cause,
+// since the review must be submitted again as a whole rather than the arguments corrected.
+func rollbackComments(bb *bitbucket.Server, projectKey, repoSlug string, pullRequestID int, created []*bitbucket.Comment, cause error) error {
+	if len(created) == 0 {
+		return cause
+	}
+
+	var failed []string
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := bb.DeletePullRequestComment(projectKey, repoSlug, pullRequestID, created[i].ID, created[i].Version); err != nil {
+			failed = append(failed, fmt.Sprintf("%d", created[i].ID))
+		}
+	}
+	if len(failed) > 0 {
+		return toolErrorf("review not submitted: %v. Rolling back failed to delete comments %s; delete them before submitting the review again", cause, strings.Join(failed, ", "))
+	}
+	return toolErrorf("review not submitted: %v. The %d comments already created were deleted again, so the review can be submitted again as a whole", cause, len(created))
+}
+
+// reviewVerdictStatus maps a submit_review verdict to a participant status, empty for COMMENT
+func reviewVerdictStatus(verdict string) string {
+	switch verdict {
+	case "APPROVE":
+		return "APPROVED"
+	case "NEEDS_WORK":
+		return "NEEDS_WORK"
+	default:
+		return ""
+	}
+}
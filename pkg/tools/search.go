@@ -0,0 +1,280 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// searchConcurrency bounds how many repositories search_pull_requests queries at the same time
+const searchConcurrency = 8
+
+// repoSearchError records a repository that could not be searched, e.g. for lack of permission
+type repoSearchError struct {
+	Repository string `json:"repository"`
+	Error      string `json:"error"`
+}
+
+// searchResult is returned by search_pull_requests in JSON format
+type searchResult struct {
+	RepositoriesSearched int               `json:"repositoriesSearched"`
+	TotalMatches         int               `json:"totalMatches"`
+	PullRequests         []dashboardRow    `json:"pullRequests"`
+	Errors               []repoSearchError `json:"errors,omitempty"`
+
+	// Repositories with more pull requests than maxPullRequestScan, of which only the first were
+	// searched, so TotalMatches may be short
+	Truncated []string `json:"truncatedRepositories,omitempty"`
+}
+
+func RegisterSearchPullRequests(s *server.MCPServer, bb *bitbucket.Server) {
+	searchTool := mcp.NewTool("search_pull_requests",
+		mcp.WithDescription("Search pull requests across every repository of one or more projects, e.g. all open pull requests older than 7 days targeting release/*. Repositories that cannot be read are reported instead of failing the search"),
+		mcp.WithString("project_keys",
+			mcp.Description("Comma-separated project keys to search (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_pattern",
+			mcp.Description("Only search repositories whose slug matches this glob, e.g. api-*"),
+		),
+		mcp.WithString("state",
+			mcp.Description("Filter by state (default: OPEN)"),
+			mcp.Enum("OPEN", "MERGED", "DECLINED", "ALL"),
+			mcp.DefaultString("OPEN"),
+		),
+		mcp.WithString("target_branch",
+			mcp.Description("Only pull requests whose target branch matches this glob, e.g. release/* or main"),
+		),
+		mcp.WithString("source_branch",
+			mcp.Description("Only pull requests whose source branch matches this glob, e.g. feature/*"),
+		),
+		mcp.WithString("author",
+			mcp.Description("Only pull requests by this user (username, slug, display name or email)"),
+		),
+		mcp.WithString("title_regex",
+			mcp.Description("Only pull requests whose title matches this regular expression (case-insensitive)"),
+		),
+		mcp.WithNumber("min_age_days",
+			mcp.Description("Only pull requests created at least this many days ago"),
+		),
+		mcp.WithString("updated_since",
+			mcp.Description("Only pull requests updated at or after this time (RFC 3339, YYYY-MM-DD or epoch milliseconds)"),
+		),
+		mcp.WithString("sort",
+			mcp.Description("Sort order of the combined results (default: OLDEST)"),
+			mcp.Enum("OLDEST", "NEWEST", "RECENTLY_UPDATED", "LEAST_RECENTLY_UPDATED"),
+			mcp.DefaultString("OLDEST"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of pull requests to return (1-1000, default: 50)"),
+		),
+		withFormat(formatMarkdown),
+		withFields(),
+		withOutputSchema[searchResult](),
+	)
+
+	s.AddTool(searchTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		var projectKeys []string
+		if keys := args.String("project_keys"); keys != "" {
+			projectKeys = splitGlobs(keys)
+		} else {
+			projectKeys = []string{args.ProjectKey(bb)}
+		}
+
+		repoPattern := args.String("repo_pattern")
+		state := args.Enum("state", "OPEN", "OPEN", "MERGED", "DECLINED", "ALL")
+		targetBranch := args.String("target_branch")
+		sourceBranch := args.String("source_branch")
+		author := args.String("author")
+		sortOrder := args.Enum("sort", "OLDEST", "OLDEST", "NEWEST", "RECENTLY_UPDATED", "LEAST_RECENTLY_UPDATED")
+		limit := args.Int("limit", 50, 1, 1000)
+		titleRegex := args.Regexp("title_regex")
+		minAge := args.Number("min_age_days")
+		updatedSince := args.Timestamp("updated_since")
+		output := args.Output(formatMarkdown)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		var createdBefore int64
+		if minAge > 0 {
+			createdBefore = now.Add(-time.Duration(minAge * float64(24*time.Hour))).UnixMilli()
+		}
+
+		match := func(pr bitbucket.PullRequest) bool {
+			return (targetBranch == "" || matchBranchGlob(targetBranch, pr.ToRef)) &&
+				(sourceBranch == "" || matchBranchGlob(sourceBranch, pr.FromRef)) &&
+				(author == "" || userMatches(pr.Author.User, author)) &&
+				(titleRegex == nil || titleRegex.MatchString(pr.Title)) &&
+				(createdBefore == 0 || pr.CreatedDate <= createdBefore) &&
+				pr.UpdatedDate >= updatedSince
+		}
+
+		// Collect the repositories first; an unreadable project is reported like an unreadable repository
+		var repos []bitbucket.Repository
+		var searchErrors []repoSearchError
+		for _, projectKey := range projectKeys {
+			projectRepos, err := bb.GetRepos(projectKey, 100, 0)
+			if err != nil {
+				searchErrors = append(searchErrors, repoSearchError{Repository: projectKey, Error: err.Error()})
+				continue
+			}
+			for _, repo := range projectRepos {
+				if repoPattern == "" || matchSlugGlob(repoPattern, repo.Slug) {
+					repos = append(repos, repo)
+				}
+			}
+		}
+
+		listOptions := bitbucket.PullRequestListOptions{
+			State:   state,
+			Match:   match,
+			MaxScan: maxPullRequestScan,
+		}
+		// Listings are newest first, so a minimum age would spend the scan on the pull requests it excludes
+		if createdBefore > 0 {
+			listOptions.Order = "OLDEST"
+		}
+		prs, truncated, repoErrors := searchRepositories(ctx, bb, repos, listOptions)
+		searchErrors = append(searchErrors, repoErrors...)
+
+		sortPullRequests(prs, sortOrder)
+
+		result := searchResult{
+			RepositoriesSearched: len(repos),
+			TotalMatches:         len(prs),
+			PullRequests:         []dashboardRow{},
+			Errors:               searchErrors,
+			Truncated:            truncated,
+		}
+		for i, pr := range prs {
+			if i >= limit {
+				break
+			}
+			result.PullRequests = append(result.PullRequests, newDashboardRow(pr, now))
+		}
+
+		if useCustomMarkdown(output) {
+			return structuredResult(result, renderSearchResult(result), nil)
+		}
+
+		return formatResult(result, output)
+	}))
+}
+
+// searchRepositories lists the matching pull requests of each repository, querying at most
+// searchConcurrency repositories at a time. Failing repositories are returned as errors, and the
+// names of repositories that opts.MaxScan cut short as truncated.
+func searchRepositories(ctx context.Context, bb *bitbucket.Server, repos []bitbucket.Repository, opts bitbucket.PullRequestListOptions) ([]bitbucket.PullRequest, []string, []repoSearchError) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		prs       []bitbucket.PullRequest
+		truncated []string
+		failures  []repoSearchError
+	)
+	sem := make(chan struct{}, searchConcurrency)
+
+	for _, repo := range repos {
+		repo := repo
+		name := repo.Project.Key + "/" + repo.Slug
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			mu.Lock()
+			failures = append(failures, repoSearchError{Repository: name, Error: ctx.Err().Error()})
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			repoPRs, cut, err := bb.ScanPullRequests(repo.Project.Key, repo.Slug, opts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, repoSearchError{Repository: name, Error: err.Error()})
+				return
+			}
+			prs = append(prs, repoPRs...)
+			if cut {
+				truncated = append(truncated, name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Repository < failures[j].Repository })
+	sort.Strings(truncated)
+	return prs, truncated, failures
+}
+
+func sortPullRequests(prs []bitbucket.PullRequest, order string) {
+	sort.SliceStable(prs, func(i, j int) bool {
+		switch order {
+		case "NEWEST":
+			return prs[i].CreatedDate > prs[j].CreatedDate
+		case "RECENTLY_UPDATED":
+			return prs[i].UpdatedDate > prs[j].UpdatedDate
+		case "LEAST_RECENTLY_UPDATED":
+			return prs[i].UpdatedDate < prs[j].UpdatedDate
+		default:
+			return prs[i].CreatedDate < prs[j].CreatedDate
+		}
+	})
+}
+
+func renderSearchResult(result searchResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Searched %d repositories, %d matching pull requests", result.RepositoriesSearched, result.TotalMatches)
+	if result.TotalMatches > len(result.PullRequests) {
+		fmt.Fprintf(&sb, " (showing %d)", len(result.PullRequests))
+	}
+	sb.WriteString("\n\n")
+
+	sb.WriteString(renderDashboardTable(result.PullRequests, false))
+
+	if len(result.Truncated) > 0 {
+		fmt.Fprintf(&sb, "\nOnly the first %d pull requests of %d repositories were searched, so matches may be missing: %s\n",
+			maxPullRequestScan, len(result.Truncated), strings.Join(result.Truncated, ", "))
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Fprintf(&sb, "\nCould not search %d repositories:\n", len(result.Errors))
+		for _, searchErr := range result.Errors {
+			fmt.Fprintf(&sb, "- %s: %s\n", searchErr.Repository, searchErr.Error)
+		}
+	}
+	return sb.String()
+}
+
+// matchBranchGlob matches a branch glob against a ref by name (release/*) or fully qualified
+// (refs/heads/release/*). * does not cross a slash, ** does.
+func matchBranchGlob(pattern string, ref bitbucket.PullRequestRef) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, ref.DisplayID)
+		return matched
+	}
+	return matchGlob(pattern, ref.DisplayID) || matchGlob(pattern, ref.ID)
+}
+
+func matchSlugGlob(pattern, slug string) bool {
+	matched, err := path.Match(strings.ToLower(pattern), slug)
+	return err == nil && matched
+}
@@ -0,0 +1,342 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// SubscriptionOptions configures how subscribed pull request resources are polled
+type SubscriptionOptions struct {
+	PollInterval     time.Duration
+	MaxSubscriptions int // Across all sessions
+}
+
+// PullRequestWatcher polls the pull requests behind subscribed resources and sends
+// notifications/resources/updated to the subscribed sessions when one gets new commits,
+// comments, approvals or a state change. Each pull request is polled with the client of the
+// subscribing session, so users with their own tokens only hear about what they may see.
+type PullRequestWatcher struct {
+	server *server.MCPServer
+	bb     *bitbucket.Server
+	opts   SubscriptionOptions
+
+	mu            sync.Mutex
+	subscriptions map[string]map[string]*bitbucket.Server // Resource URI to subscribed session IDs and their clients
+	snapshots     map[pollKey]pullRequestSnapshot         // State seen last
+	polling       map[pollKey]bool                        // Being polled right now
+}
+
+// pullRequestSnapshot holds what is compared between polls. New comments, replies and
+// approvals show up as a new activity, pushes as a new commit.
+type pullRequestSnapshot struct {
+	UpdatedDate    int64
+	State          string
+	FromCommit     string
+	ToCommit       string
+	LatestActivity int
+}
+
+// pullRequestTarget identifies the pull request behind a subscribable resource URI
+type pullRequestTarget struct {
+	projectKey string
+	repoSlug   string
+	id         int
+}
+
+// pollKey is a pull request as seen by one client
+type pollKey struct {
+	bb  *bitbucket.Server
+	uri string
+}
+
+// pollTarget is a pull request to poll and the client to poll it with
+type pollTarget struct {
+	bb     *bitbucket.Server
+	target pullRequestTarget
+}
+
+func (t pullRequestTarget) uri() string {
+	return pullRequestURI(t.projectKey, t.repoSlug, t.id)
+}
+
+var subscribableURI = regexp.MustCompile(`^bitbucket://([^/]+)/([^/]+)/pull-requests/(\d+)(/diff)?$`)
+
+// parseSubscribableURI accepts pull request and pull request diff resource URIs
+func parseSubscribableURI(uri string) (pullRequestTarget, bool) {
+	match := subscribableURI.FindStringSubmatch(uri)
+	if match == nil {
+		return pullRequestTarget{}, false
+	}
+	id, err := strconv.Atoi(match[3])
+	if err != nil || id <= 0 {
+		return pullRequestTarget{}, false
+	}
+	return pullRequestTarget{projectKey: match[1], repoSlug: match[2], id: id}, true
+}
+
+func pullRequestURI(projectKey, repoSlug string, pullRequestID int) string {
+	return fmt.Sprintf("bitbucket://%s/%s/pull-requests/%d", projectKey, repoSlug, pullRequestID)
+}
+
+func NewPullRequestWatcher(s *server.MCPServer, bb *bitbucket.Server, opts SubscriptionOptions) *PullRequestWatcher {
+	return &PullRequestWatcher{
+		server:        s,
+		bb:            bb,
+		opts:          opts,
+		subscriptions: make(map[string]map[string]*bitbucket.Server),
+		snapshots:     make(map[pollKey]pullRequestSnapshot),
+		polling:       make(map[pollKey]bool),
+	}
+}
+
+// Register tracks subscriptions through the server hooks. The hooks must be the ones the
+// server was created with. Subscriptions are taken before the request is handled so that ones
+// that would never be notified are rejected with an error instead of acknowledged.
+func (w *PullRequestWatcher) Register(hooks *server.Hooks) {
+	hooks.AddOnRequestInitialization(func(ctx context.Context, id any, message any) error {
+		raw, ok := message.(json.RawMessage)
+		if !ok {
+			return nil
+		}
+		var request mcp.SubscribeRequest
+		if err := json.Unmarshal(raw, &request); err != nil || request.Method != string(mcp.MethodResourcesSubscribe) {
+			return nil
+		}
+		session := server.ClientSessionFromContext(ctx)
+		if session == nil {
+			return nil
+		}
+		return w.subscribe(session.SessionID(), request.Params.URI, w.bb.ForContext(ctx))
+	})
+	hooks.AddAfterUnsubscribe(func(ctx context.Context, id any, request *mcp.UnsubscribeRequest, result *mcp.EmptyResult) {
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			w.unsubscribe(session.SessionID(), request.Params.URI)
+		}
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		w.removeSession(session.SessionID())
+	})
+}
+
+// Run polls the subscribed pull requests until the context is cancelled
+func (w *PullRequestWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, target := range w.targets() {
+				if ctx.Err() != nil {
+					return
+				}
+				w.poll(target.bb, target.target)
+			}
+		}
+	}
+}
+
+func (w *PullRequestWatcher) subscribe(sessionID, uri string, bb *bitbucket.Server) error {
+	target, ok := parseSubscribableURI(uri)
+	if !ok {
+		return fmt.Errorf("cannot subscribe to %s: only pull request and pull request diff resources can be subscribed to", uri)
+	}
+
+	w.mu.Lock()
+	if _, ok := w.subscriptions[uri][sessionID]; !ok && w.countLocked() >= w.opts.MaxSubscriptions {
+		w.mu.Unlock()
+		return fmt.Errorf("cannot subscribe to %s: the limit of %d subscriptions is reached", uri, w.opts.MaxSubscriptions)
+	}
+	if w.subscriptions[uri] == nil {
+		w.subscriptions[uri] = make(map[string]*bitbucket.Server)
+	}
+	w.subscriptions[uri][sessionID] = bb
+	_, seen := w.snapshots[pollKey{bb: bb, uri: target.uri()}]
+	w.mu.Unlock()
+
+	// Take the baseline right away so changes before the first poll are not missed
+	if !seen {
+		go w.poll(bb, target)
+	}
+	return nil
+}
+
+func (w *PullRequestWatcher) unsubscribe(sessionID, uri string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.subscriptions[uri], sessionID)
+	if len(w.subscriptions[uri]) == 0 {
+		delete(w.subscriptions, uri)
+	}
+	w.pruneLocked()
+}
+
+func (w *PullRequestWatcher) removeSession(sessionID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for uri, sessions := range w.subscriptions {
+		delete(sessions, sessionID)
+		if len(sessions) == 0 {
+			delete(w.subscriptions, uri)
+		}
+	}
+	w.pruneLocked()
+}
+
+func (w *PullRequestWatcher) countLocked() int {
+	count := 0
+	for _, sessions := range w.subscriptions {
+		count += len(sessions)
+	}
+	return count
+}
+
+// pruneLocked forgets the snapshots of pull requests nobody is subscribed to anymore
+func (w *PullRequestWatcher) pruneLocked() {
+	watched := make(map[pollKey]bool)
+	for uri, sessions := range w.subscriptions {
+		if target, ok := parseSubscribableURI(uri); ok {
+			for _, bb := range sessions {
+				watched[pollKey{bb: bb, uri: target.uri()}] = true
+			}
+		}
+	}
+	for key := range w.snapshots {
+		if !watched[key] {
+			delete(w.snapshots, key)
+		}
+	}
+}
+
+// targets returns each subscribed pull request once per client, even when both it and its diff
+// are subscribed
+func (w *PullRequestWatcher) targets() []pollTarget {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seen := make(map[pollKey]bool)
+	var targets []pollTarget
+	for uri, sessions := range w.subscriptions {
+		target, ok := parseSubscribableURI(uri)
+		if !ok {
+			continue
+		}
+		for _, bb := range sessions {
+			key := pollKey{bb: bb, uri: target.uri()}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			targets = append(targets, pollTarget{bb: bb, target: target})
+		}
+	}
+	return targets
+}
+
+// sessionsLocked returns the sessions subscribed to uri with the given client
+func (w *PullRequestWatcher) sessionsLocked(uri string, bb *bitbucket.Server) []string {
+	var sessionIDs []string
+	for _, sessionID := range sortedKeys(w.subscriptions[uri]) {
+		if w.subscriptions[uri][sessionID] == bb {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+	return sessionIDs
+}
+
+// poll fetches the current state of a pull request and notifies the subscribers of its
+// resources when it differs from the previous poll. The first poll only records the state. A
+// pull request already being polled with the same client, e.g. for the baseline taken on
+// subscribe, is skipped so that an older state never replaces a newer one.
+func (w *PullRequestWatcher) poll(bb *bitbucket.Server, target pullRequestTarget) {
+	prURI := target.uri()
+	diffURI := prURI + "/diff"
+
+	key := pollKey{bb: bb, uri: prURI}
+
+	w.mu.Lock()
+	if w.polling[key] {
+		w.mu.Unlock()
+		return
+	}
+	w.polling[key] = true
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.polling, key)
+		w.mu.Unlock()
+	}()
+
+	snapshot, err := fetchSnapshot(bb, target)
+	if err != nil {
+		log.Printf("Failed to poll %s: %v", prURI, err)
+		return
+	}
+
+	w.mu.Lock()
+	previous, seen := w.snapshots[key]
+	prSessions, diffSessions := w.sessionsLocked(prURI, bb), w.sessionsLocked(diffURI, bb)
+	if len(prSessions) == 0 && len(diffSessions) == 0 {
+		// Unsubscribed while the request was running
+		w.mu.Unlock()
+		return
+	}
+	w.snapshots[key] = snapshot
+	w.mu.Unlock()
+
+	updated := make(map[string][]string)
+	if seen && snapshot != previous {
+		updated[prURI] = prSessions
+		// The diff only changes when either branch moves
+		if snapshot.FromCommit != previous.FromCommit || snapshot.ToCommit != previous.ToCommit {
+			updated[diffURI] = diffSessions
+		}
+	}
+
+	for uri, sessionIDs := range updated {
+		for _, sessionID := range sessionIDs {
+			err := w.server.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri})
+			if errors.Is(err, server.ErrSessionNotFound) {
+				// The session ended without being unregistered through the hooks
+				w.removeSession(sessionID)
+			} else if err != nil {
+				log.Printf("Failed to notify session %s about %s: %v", sessionID, uri, err)
+			}
+		}
+	}
+}
+
+func fetchSnapshot(bb *bitbucket.Server, target pullRequestTarget) (pullRequestSnapshot, error) {
+	pr, err := bb.GetPullRequest(target.projectKey, target.repoSlug, target.id)
+	if err != nil {
+		return pullRequestSnapshot{}, err
+	}
+
+	activityID, err := bb.GetLatestPullRequestActivityID(target.projectKey, target.repoSlug, target.id)
+	if err != nil {
+		return pullRequestSnapshot{}, err
+	}
+
+	return pullRequestSnapshot{
+		UpdatedDate:    pr.UpdatedDate,
+		State:          pr.State,
+		FromCommit:     pr.FromRef.LatestCommit,
+		ToCommit:       pr.ToRef.LatestCommit,
+		LatestActivity: activityID,
+	}, nil
+}
@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func RegisterSuggestChange(s *server.MCPServer, bb *bitbucket.Server) {
+	suggestTool := mcp.NewTool("suggest_change",
+		mcp.WithDescription("Post an inline comment with a suggested change that reviewers can apply with one click (Bitbucket 7.x+, 8.0+ for several lines). The lines must be added or context lines of one hunk of the pull request diff"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithString("path",
+			mcp.Required(),
+			mcp.Description("Path of the file in the source branch"),
+		),
+		mcp.WithNumber("start_line",
+			mcp.Required(),
+			mcp.Description("First line (in the new version of the file) to replace"),
+		),
+		mcp.WithNumber("end_line",
+			mcp.Description("Last line to replace (defaults to start_line). Ranges of several lines need Bitbucket 8.0+"),
+		),
+		mcp.WithString("replacement",
+			mcp.Required(),
+			mcp.Description("Replacement text for the selected lines. Use an empty string to suggest deleting them"),
+		),
+		mcp.WithString("text",
+			mcp.Description("Optional explanation shown above the suggestion"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Comment](),
+	)
+
+	s.AddTool(suggestTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		path := args.RequiredString("path")
+		startLine := args.ID("start_line")
+		endLine := args.OptionalID("end_line")
+		text := args.String("text")
+
+		// An empty replacement suggests deleting the lines, so it only has to be present
+		replacement := args.String("replacement")
+		if !args.Has("replacement") {
+			args.problemf("replacement is required; pass an empty string to suggest deleting the lines")
+		}
+
+		if endLine == 0 {
+			endLine = startLine
+		}
+		if endLine < startLine {
+			args.problemf("end_line %d must not be before start_line %d", endLine, startLine)
+		}
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		// Older servers ignore the multi-line marker and would anchor the suggestion on end_line alone,
+		// so applying it would replace that one line with the whole replacement
+		if startLine != endLine {
+			multiline, err := bb.SupportsMultilineComments()
+			if err != nil {
+				return nil, fmt.Errorf("failed to check for multi-line comment support: %w", err)
+			}
+			if !multiline {
+				return nil, toolErrorf("suggestions spanning several lines need Bitbucket 8.0 or later; suggest a change to a single line (end_line equal to start_line) instead")
+			}
+		}
+
+		diff, err := bb.GetPullRequestStructuredDiff(projectKey, repoSlug, pullRequestID, 0, "", "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request diff: %w", err)
+		}
+
+		// Every line of the range must be on the new side of the same hunk
+		anchor, err := diff.AnchorForRange(path, startLine, endLine)
+		if err != nil {
+			return nil, toolErrorf("cannot suggest a change here: %v", err)
+		}
+
+		comment, err := bb.CreatePullRequestComment(projectKey, repoSlug, pullRequestID, formatSuggestion(text, replacement), anchor, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create suggestion: %w", err)
+		}
+
+		return formatResult(comment, output)
+	}))
+}
+
+// formatSuggestion builds the comment markdown for a suggestion block. The fence is made longer
+// than any backtick run in the replacement so code containing fences survives intact.
+func formatSuggestion(text, replacement string) string {
+	fence := "```"
+	for strings.Contains(replacement, fence) {
+		fence += "`"
+	}
+
+	var sb strings.Builder
+	if strings.TrimSpace(text) != "" {
+		sb.WriteString(strings.TrimSpace(text))
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString(fence + "suggestion\n")
+	if replacement != "" {
+		sb.WriteString(strings.TrimSuffix(replacement, "\n"))
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fence)
+	return sb.String()
+}
@@ -4,31 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
 
 	"bbcli/pkg/bitbucket"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
-// getProjectKey returns the project key from args or falls back to the default from config
-func getProjectKey(args map[string]interface{}, bb *bitbucket.Server) (string, error) {
-	// Try to get explicit project_key from args
-	if projectKey, ok := args["project_key"].(string); ok && projectKey != "" {
-		return projectKey, nil
-	}
-	
-	// Fall back to default project key from config
-	defaultKey := bb.GetDefaultProjectKey()
-	if defaultKey == "" {
-		return "", fmt.Errorf("no project_key provided and no default project key configured. Set BITBUCKET_DEFAULT_PROJECT_KEY environment variable or provide project_key parameter")
-	}
-	
-	return defaultKey, nil
-}
-
 func RegisterListPullRequests(s *server.MCPServer, bb *bitbucket.Server) {
 	listPRTool := mcp.NewTool("list_pull_requests",
-		mcp.WithDescription("List pull requests for a repository"),
+		mcp.WithDescription("List pull requests for a repository, with Bitbucket's server-side filters plus client-side author, title and update time filters"),
 		mcp.WithString("project_key",
 			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
 		),
@@ -37,48 +23,124 @@ func RegisterListPullRequests(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Description("The repository slug"),
 		),
 		mcp.WithString("state",
-			mcp.Description("Filter by state (OPEN, MERGED, DECLINED)"),
-			mcp.Enum("OPEN", "MERGED", "DECLINED"),
+			mcp.Description("Filter by state (OPEN, MERGED, DECLINED, ALL)"),
+			mcp.Enum("OPEN", "MERGED", "DECLINED", "ALL"),
+		),
+		mcp.WithString("direction",
+			mcp.Description("INCOMING lists pull requests targeting the branch given by at, OUTGOING those from it (default: INCOMING)"),
+			mcp.Enum("INCOMING", "OUTGOING"),
+		),
+		mcp.WithString("at",
+			mcp.Description("Branch to filter by, e.g. main or refs/heads/main. Combined with direction"),
+		),
+		mcp.WithString("order",
+			mcp.Description("Sort order (default: NEWEST)"),
+			mcp.Enum("NEWEST", "OLDEST"),
+		),
+		mcp.WithBoolean("with_attributes",
+			mcp.Description("Include pull request attributes in the response"),
+		),
+		mcp.WithBoolean("with_properties",
+			mcp.Description("Include pull request properties such as comment and task counts in the response"),
+		),
+		mcp.WithString("participants_json",
+			mcp.Description("JSON array of participant filters, each with username and optionally role (AUTHOR, REVIEWER, PARTICIPANT) and approved (true or false)"),
+		),
+		mcp.WithString("author",
+			mcp.Description("Only pull requests by this user (username, slug, display name or email). Filtered client-side"),
+		),
+		mcp.WithString("title_regex",
+			mcp.Description("Only pull requests whose title matches this regular expression (case-insensitive). Filtered client-side"),
+		),
+		mcp.WithString("updated_since",
+			mcp.Description("Only pull requests updated at or after this time (RFC 3339, YYYY-MM-DD or epoch milliseconds). Filtered client-side"),
 		),
 		mcp.WithNumber("limit",
-			mcp.Description("Maximum number of results to return (1-100)"),
+			mcp.Description("Maximum number of results to return (1-1000, default: 25)"),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[valuesResult[bitbucket.PullRequest]](),
 	)
 
-	s.AddTool(listPRTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(listPRTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		opts := pullRequestListOptions(args)
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		state, _ := args["state"].(string)
 
-		limit := 25
-		if limitVal, ok := args["limit"].(float64); ok {
-			limit = int(limitVal)
+		prs, err := bb.GetPullRequests(projectKey, repoSlug, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull requests: %w", err)
+		}
+		if prs == nil {
+			prs = []bitbucket.PullRequest{}
 		}
 
-		prs, err := bb.GetPullRequests(projectKey, repoSlug, state, limit)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get pull requests: %v", err)
+		return formatResult(prs, output, "id", "title", "author", "age", "approvals")
+	}))
+}
+
+// maxPullRequestScan bounds how many pull requests the client-side filters of list_pull_requests look at
+const maxPullRequestScan = 1000
+
+// pullRequestListOptions turns the list_pull_requests arguments into listing options
+func pullRequestListOptions(args *toolArgs) bitbucket.PullRequestListOptions {
+	opts := bitbucket.PullRequestListOptions{
+		State:          args.Enum("state", "", "OPEN", "MERGED", "DECLINED", "ALL"),
+		Direction:      args.Enum("direction", "", "INCOMING", "OUTGOING"),
+		Order:          args.Enum("order", "", "NEWEST", "OLDEST"),
+		WithAttributes: args.OptionalBool("with_attributes"),
+		WithProperties: args.OptionalBool("with_properties"),
+		Limit:          args.Int("limit", 25, 1, 1000),
+	}
+
+	if at := args.String("at"); at != "" {
+		if !strings.HasPrefix(at, "refs/") {
+			at = "refs/heads/" + at
 		}
+		opts.At = at
+	} else if opts.Direction != "" {
+		args.problemf("direction requires at to name the branch")
+	}
 
-		content, err := json.MarshalIndent(prs, "", "  ")
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
+	if participantsJSON := args.String("participants_json"); participantsJSON != "" {
+		if err := json.Unmarshal([]byte(participantsJSON), &opts.Participants); err != nil {
+			args.problemf("participants_json must be a JSON array of participant filters: %v", err)
 		}
+		for i, participant := range opts.Participants {
+			if participant.Username == "" {
+				args.problemf("participants_json filter %d: username is required", i+1)
+			}
+			switch participant.Role {
+			case "", "AUTHOR", "REVIEWER", "PARTICIPANT":
+			default:
+				args.problemf("participants_json filter %d: role must be AUTHOR, REVIEWER or PARTICIPANT", i+1)
+			}
+		}
+	}
 
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+	// Client-side filters
+	author := args.String("author")
+	titleRegex := args.Regexp("title_regex")
+	updatedSince := args.Timestamp("updated_since")
+
+	if author != "" || titleRegex != nil || updatedSince > 0 {
+		opts.MaxScan = maxPullRequestScan
+		opts.Match = func(pr bitbucket.PullRequest) bool {
+			return (author == "" || userMatches(pr.Author.User, author)) &&
+				(titleRegex == nil || titleRegex.MatchString(pr.Title)) &&
+				pr.UpdatedDate >= updatedSince
+		}
+	}
+
+	return opts
 }
 
 func RegisterGetPullRequest(s *server.MCPServer, bb *bitbucket.Server) {
@@ -95,37 +157,30 @@ func RegisterGetPullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Required(),
 			mcp.Description("The pull request ID"),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.PullRequest](),
 	)
 
-	s.AddTool(getPRTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(getPRTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
 
-		pr, err := bb.GetPullRequest(projectKey, repoSlug, int(pullRequestID))
+		pr, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get pull request: %v", err)
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
 		}
 
-		content, err := json.MarshalIndent(pr, "", "  ")
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
-		}
-
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+		return formatResult(pr, output)
+	}))
 }
 
 func RegisterGetPullRequestActivity(s *server.MCPServer, bb *bitbucket.Server) {
@@ -142,37 +197,30 @@ func RegisterGetPullRequestActivity(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Required(),
 			mcp.Description("The pull request ID"),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.PullRequestActivity](),
 	)
 
-	s.AddTool(getActivityTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(getActivityTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
 
-		activity, err := bb.GetPullRequestActivity(projectKey, repoSlug, int(pullRequestID))
+		activity, err := bb.GetPullRequestActivity(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get pull request activity: %v", err)
+			return nil, fmt.Errorf("failed to get pull request activity: %w", err)
 		}
 
-		content, err := json.MarshalIndent(activity, "", "  ")
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
-		}
-
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+		return formatResult(activity, output)
+	}))
 }
 
 func RegisterCreatePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
@@ -200,20 +248,25 @@ func RegisterCreatePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 		mcp.WithString("description",
 			mcp.Description("The pull request description"),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.PullRequest](),
 	)
 
-	s.AddTool(createPRTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
-
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+	s.AddTool(createPRTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		title := args.RequiredString("title")
+		fromBranch := args.RequiredString("from_branch")
+		toBranch := args.RequiredString("to_branch")
+		description := args.String("description")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		title, _ := args["title"].(string)
-		fromBranch, _ := args["from_branch"].(string)
-		toBranch, _ := args["to_branch"].(string)
-		description, _ := args["description"].(string)
 
 		// Create the pull request structure
 		pr := &bitbucket.PullRequest{
@@ -241,23 +294,11 @@ func RegisterCreatePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 
 		createdPR, err := bb.CreatePullRequest(projectKey, repoSlug, pr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create pull request: %v", err)
-		}
-
-		content, err := json.MarshalIndent(createdPR, "", "  ")
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
+			return nil, fmt.Errorf("failed to create pull request: %w", err)
 		}
 
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+		return formatResult(createdPR, output)
+	}))
 }
 
 func RegisterApprovePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
@@ -276,19 +317,20 @@ func RegisterApprovePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 		),
 	)
 
-	s.AddTool(approveTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(approveTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
 
-		err = bb.ApprovePullRequest(projectKey, repoSlug, int(pullRequestID))
+		err := bb.ApprovePullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to approve pull request: %v", err)
+			return nil, fmt.Errorf("failed to approve pull request: %w", err)
 		}
 
 		return &mcp.CallToolResult{
@@ -299,12 +341,12 @@ func RegisterApprovePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 				},
 			},
 		}, nil
-	})
+	}))
 }
 
 func RegisterUnapprovePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 	unapproveTool := mcp.NewTool("unapprove_pull_request",
-		mcp.WithDescription("Remove approval from a pull request"),
+		mcp.WithDescription("Remove your approval or needs-work status from a pull request"),
 		mcp.WithString("project_key",
 			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
 		),
@@ -318,19 +360,20 @@ func RegisterUnapprovePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 		),
 	)
 
-	s.AddTool(unapproveTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(unapproveTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
 
-		err = bb.UnapprovalPullRequest(projectKey, repoSlug, int(pullRequestID))
+		err := bb.UnapprovalPullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unapprove pull request: %v", err)
+			return nil, fmt.Errorf("failed to unapprove pull request: %w", err)
 		}
 
 		return &mcp.CallToolResult{
@@ -341,7 +384,67 @@ func RegisterUnapprovePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 				},
 			},
 		}, nil
-	})
+	}))
+}
+
+func RegisterSetReviewStatus(s *server.MCPServer, bb *bitbucket.Server) {
+	setStatusTool := mcp.NewTool("set_review_status",
+		mcp.WithDescription("Set your review status on a pull request: APPROVED, NEEDS_WORK, or UNAPPROVED to clear it"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithString("status",
+			mcp.Required(),
+			mcp.Description("The review status"),
+			mcp.Enum("APPROVED", "NEEDS_WORK", "UNAPPROVED"),
+		),
+		mcp.WithString("last_reviewed_commit",
+			mcp.Description("Hash of the latest commit you reviewed (optional)"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Participant](),
+	)
+
+	s.AddTool(setStatusTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		status := args.RequiredString("status")
+		if status != "" {
+			status = args.Enum("status", "", "APPROVED", "NEEDS_WORK", "UNAPPROVED")
+		}
+		lastReviewedCommit := args.String("last_reviewed_commit")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		participant, err := bb.SetReviewStatus(projectKey, repoSlug, pullRequestID, status, lastReviewedCommit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set review status: %w", err)
+		}
+
+		return formatResult(participant, output)
+	}))
+}
+
+// mergeResult is the merged pull request plus, when requested, the outcome of deleting its source branch
+type mergeResult struct {
+	bitbucket.PullRequest
+	SourceBranchDeleted *bool  `json:"sourceBranchDeleted,omitempty"`
+	SourceBranchError   string `json:"sourceBranchError,omitempty"`
 }
 
 func RegisterMergePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
@@ -358,43 +461,134 @@ func RegisterMergePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Required(),
 			mcp.Description("The pull request ID"),
 		),
+		mcp.WithBoolean("delete_source_branch",
+			mcp.Description("Delete the source branch after merging. Only allowed when the source branch is in the same repository and not protected by branch permissions (default: false)"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[mergeResult](),
 	)
 
-	s.AddTool(mergeTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(mergeTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		deleteSourceBranch := args.Bool("delete_source_branch", false)
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
 
 		// Get current PR to obtain the latest version for optimistic locking
-		currentPR, err := bb.GetPullRequest(projectKey, repoSlug, int(pullRequestID))
+		currentPR, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get current pull request version: %v", err)
+			return nil, fmt.Errorf("failed to get current pull request version: %w", err)
+		}
+
+		// Check the branch can be deleted before merging, so the request fails as a whole
+		if deleteSourceBranch {
+			if err := checkSourceBranchDeletable(bb, currentPR); err != nil {
+				return nil, toolErrorf("pull request not merged because the source branch cannot be deleted: %v. Merge without delete_source_branch instead", err)
+			}
 		}
 
-		mergedPR, err := bb.MergePullRequest(projectKey, repoSlug, int(pullRequestID), currentPR.Version)
+		mergedPR, err := bb.MergePullRequest(projectKey, repoSlug, pullRequestID, currentPR.Version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge pull request: %v", err)
+			return nil, fmt.Errorf("failed to merge pull request: %w", err)
+		}
+
+		result := mergeResult{PullRequest: *mergedPR}
+		if deleteSourceBranch {
+			deleted := true
+			fromRepo := currentPR.FromRef.Repository
+			if err := bb.DeleteBranch(fromRepo.Project.Key, fromRepo.Slug, currentPR.FromRef.ID, currentPR.FromRef.LatestCommit, false); err != nil {
+				deleted = false
+				result.SourceBranchError = err.Error()
+			}
+			result.SourceBranchDeleted = &deleted
 		}
 
-		content, err := json.MarshalIndent(mergedPR, "", "  ")
+		return formatResult(result, output)
+	}))
+}
+
+// checkSourceBranchDeletable verifies that the source branch of a pull request lives in the target
+// repository, is not protected against deletion and that the current user may delete it
+func checkSourceBranchDeletable(bb *bitbucket.Server, pr *bitbucket.PullRequest) error {
+	fromRepo, toRepo := pr.FromRef.Repository, pr.ToRef.Repository
+	if fromRepo.ID != toRepo.ID {
+		return fmt.Errorf("source branch is in the fork %s/%s, not in the target repository", fromRepo.Project.Key, fromRepo.Slug)
+	}
+
+	restricted, err := bb.IsBranchDeletionRestricted(fromRepo.Project.Key, fromRepo.Slug, pr.FromRef.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check branch permissions: %w", err)
+	}
+	if restricted {
+		return fmt.Errorf("branch %s is protected against deletion", pr.FromRef.DisplayID)
+	}
+
+	if err := bb.DeleteBranch(fromRepo.Project.Key, fromRepo.Slug, pr.FromRef.ID, pr.FromRef.LatestCommit, true); err != nil {
+		return fmt.Errorf("branch %s cannot be deleted: %v", pr.FromRef.DisplayID, err)
+	}
+
+	return nil
+}
+
+func RegisterDeletePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
+	deleteTool := mcp.NewTool("delete_pull_request",
+		mcp.WithDescription("Permanently delete a pull request (requires repository admin permission, automatically fetches current version for optimistic locking). Prefer decline_pull_request unless the pull request must be removed"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+	)
+
+	s.AddTool(deleteTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		if bb.IsReadOnly() {
+			return nil, fmt.Errorf("delete_pull_request: %w", bitbucket.ErrReadOnly)
+		}
+
+		// Get current PR to obtain the latest version for optimistic locking
+		currentPR, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
+			return nil, fmt.Errorf("failed to get current pull request version: %w", err)
+		}
+
+		err = bb.DeletePullRequest(projectKey, repoSlug, pullRequestID, currentPR.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete pull request: %w", err)
 		}
 
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
 					Type: "text",
-					Text: string(content),
+					Text: "Pull request deleted successfully",
 				},
 			},
 		}, nil
-	})
+	}))
 }
 
 func RegisterDeclinePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
@@ -411,48 +605,87 @@ func RegisterDeclinePullRequest(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Required(),
 			mcp.Description("The pull request ID"),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.PullRequest](),
 	)
 
-	s.AddTool(declineTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(declineTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
 
 		// Get current PR to obtain the latest version for optimistic locking
-		currentPR, err := bb.GetPullRequest(projectKey, repoSlug, int(pullRequestID))
+		currentPR, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get current pull request version: %v", err)
+			return nil, fmt.Errorf("failed to get current pull request version: %w", err)
 		}
 
-		declinedPR, err := bb.DeclinePullRequest(projectKey, repoSlug, int(pullRequestID), currentPR.Version)
+		declinedPR, err := bb.DeclinePullRequest(projectKey, repoSlug, pullRequestID, currentPR.Version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decline pull request: %v", err)
+			return nil, fmt.Errorf("failed to decline pull request: %w", err)
+		}
+
+		return formatResult(declinedPR, output)
+	}))
+}
+
+func RegisterReopenPullRequest(s *server.MCPServer, bb *bitbucket.Server) {
+	reopenTool := mcp.NewTool("reopen_pull_request",
+		mcp.WithDescription("Reopen a declined pull request (automatically fetches current version for optimistic locking)"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.PullRequest](),
+	)
+
+	s.AddTool(reopenTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
 		}
 
-		content, err := json.MarshalIndent(declinedPR, "", "  ")
+		// Get current PR to obtain the latest version for optimistic locking
+		currentPR, err := bb.GetPullRequest(projectKey, repoSlug, pullRequestID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
+			return nil, fmt.Errorf("failed to get current pull request version: %w", err)
 		}
 
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+		reopenedPR, err := bb.ReopenPullRequest(projectKey, repoSlug, pullRequestID, currentPR.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reopen pull request: %w", err)
+		}
+
+		return formatResult(reopenedPR, output)
+	}))
 }
 
 func RegisterGetPullRequestDiff(s *server.MCPServer, bb *bitbucket.Server) {
 	getDiffTool := mcp.NewTool("get_pull_request_diff",
-		mcp.WithDescription("Get the raw diff for a pull request"),
+		mcp.WithDescription("Get the diff for a pull request as unified diff text with a per-file stats header. Large diffs are split into pages: pass the returned cursor to continue. Generated, vendored and lock files are skipped by default"),
 		mcp.WithString("project_key",
 			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
 		),
@@ -477,42 +710,77 @@ func RegisterGetPullRequestDiff(s *server.MCPServer, bb *bitbucket.Server) {
 		mcp.WithString("until",
 			mcp.Description("End commit hash to diff to (optional)"),
 		),
+		mcp.WithString("path",
+			mcp.Description("Only show the diff of this file (optional, never skipped as generated)"),
+		),
+		mcp.WithString("include",
+			mcp.Description("Comma-separated glob patterns of files to show, e.g. 'src/**/*.go,*.md' (optional)"),
+		),
+		mcp.WithString("exclude",
+			mcp.Description("Comma-separated glob patterns of files to hide (optional)"),
+		),
+		mcp.WithBoolean("skip_generated",
+			mcp.Description("Skip generated, vendored and lock files and list them in the summary instead (default: true)"),
+			mcp.DefaultBool(true),
+		),
+		mcp.WithNumber("max_bytes",
+			mcp.Description("Approximate maximum size of one page of diff in bytes (default: 60000, 0 for no limit)"),
+			mcp.DefaultNumber(60000),
+		),
+		mcp.WithNumber("max_lines",
+			mcp.Description("Maximum number of diff lines in one page (optional, 0 for no limit)"),
+		),
+		mcp.WithString("cursor",
+			mcp.Description("Continuation cursor returned by a previous call with the same filters"),
+		),
 	)
 
-	s.AddTool(getDiffTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(getDiffTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
-			return nil, err
-		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
 
 		// Optional parameters
-		contextLines := 0
-		if contextVal, ok := args["context_lines"].(float64); ok {
-			contextLines = int(contextVal)
+		contextLines := args.Int("context_lines", 0, 0, 10000)
+		whitespace := args.Enum("whitespace", "", "ignore-all", "ignore-space-at-eol", "ignore-space-change", "ignore-trailing-space")
+		since := args.String("since")
+		until := args.String("until")
+
+		opts := diffPageOptions{
+			Path:          args.String("path"),
+			Cursor:        args.String("cursor"),
+			Include:       splitGlobs(args.String("include")),
+			Exclude:       splitGlobs(args.String("exclude")),
+			SkipGenerated: args.Bool("skip_generated", true),
+			MaxBytes:      args.Int("max_bytes", 60000, 0, math.MaxInt32),
+			MaxLines:      args.Int("max_lines", 0, 0, math.MaxInt32),
+		}
+		if err := args.Err(); err != nil {
+			return nil, err
 		}
 
-		whitespace, _ := args["whitespace"].(string)
-		since, _ := args["since"].(string)
-		until, _ := args["until"].(string)
+		diff, err := bb.GetPullRequestStructuredDiff(projectKey, repoSlug, pullRequestID, contextLines, whitespace, since, until)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request diff: %w", err)
+		}
 
-		diff, err := bb.GetPullRequestDiff(projectKey, repoSlug, int(pullRequestID), contextLines, whitespace, since, until)
+		page, err := renderDiffPage(diff, opts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get pull request diff: %v", err)
+			return nil, err
 		}
 
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
 					Type: "text",
-					Text: diff,
+					Text: page,
 				},
 			},
 		}, nil
-	})
+	}))
 }
 
 func RegisterCreatePullRequestComment(s *server.MCPServer, bb *bitbucket.Server) {
@@ -534,84 +802,553 @@ func RegisterCreatePullRequestComment(s *server.MCPServer, bb *bitbucket.Server)
 			mcp.Description("The comment text"),
 		),
 		mcp.WithString("anchor_json",
-			mcp.Description("Optional JSON-encoded anchor for inline comments (contains: line, line_type, path, file_type, from_hash, to_hash, src_path, dst_path, diff_type, orphaned_type)"),
+			mcp.Description("Optional JSON-encoded anchor for inline comments (contains: line, line_type, path, file_type, from_hash, to_hash, src_path, dst_path, diff_type, orphaned_type). line_type and file_type are worked out from the diff when omitted"),
 		),
+		mcp.WithNumber("parent_id",
+			mcp.Description("Optional ID of the comment to reply to. Replies join the parent's thread and cannot have an anchor"),
+		),
+		mcp.WithString("severity",
+			mcp.Description("Comment severity (Bitbucket 7.x+). BLOCKER turns the comment into a task that must be resolved"),
+			mcp.Enum("NORMAL", "BLOCKER"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Comment](),
 	)
 
-	s.AddTool(commentTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
-
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+	s.AddTool(commentTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		text := args.RequiredString("text")
+		parentID := args.OptionalID("parent_id")
+		severity := args.Enum("severity", "", "NORMAL", "BLOCKER")
+
+		var anchorData map[string]interface{}
+		if anchorJSON := args.String("anchor_json"); anchorJSON != "" {
+			if parentID > 0 {
+				args.problemf("anchor_json cannot be combined with parent_id; replies inherit the anchor of their thread")
+			} else if err := json.Unmarshal([]byte(anchorJSON), &anchorData); err != nil {
+				args.problemf("anchor_json must be a JSON object: %v", err)
+			}
+		}
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
-		pullRequestID, _ := args["pull_request_id"].(float64)
-		text, _ := args["text"].(string)
+
+		// Replies are posted to the parent's thread instead of creating a new top-level comment
+		if parentID > 0 {
+			comment, err := bb.ReplyToPullRequestComment(projectKey, repoSlug, pullRequestID, parentID, text)
+			if err != nil {
+				return nil, fmt.Errorf("failed to reply to pull request comment: %w", err)
+			}
+
+			return formatResult(comment, output)
+		}
 
 		// Optional anchor for inline comments
 		var anchor *bitbucket.CommentAnchor
-		if anchorJSON, ok := args["anchor_json"].(string); ok && anchorJSON != "" {
+		if anchorData != nil {
 			anchor = &bitbucket.CommentAnchor{}
-			var anchorData map[string]interface{}
-			if err := json.Unmarshal([]byte(anchorJSON), &anchorData); err == nil {
-				if line, ok := anchorData["line"].(float64); ok {
-					anchor.Line = int(line)
-				}
-				if lineType, ok := anchorData["line_type"].(string); ok {
-					anchor.LineType = lineType
-				}
-				if path, ok := anchorData["path"].(string); ok {
-					anchor.Path = path
-				}
-				if fileType, ok := anchorData["file_type"].(string); ok {
-					anchor.FileType = fileType
-				}
-				if fromHash, ok := anchorData["from_hash"].(string); ok {
-					anchor.FromHash = fromHash
-				}
-				if toHash, ok := anchorData["to_hash"].(string); ok {
-					anchor.ToHash = toHash
-				}
-				if srcPath, ok := anchorData["src_path"].(string); ok {
-					anchor.SrcPath = srcPath
-				}
-				if dstPath, ok := anchorData["dst_path"].(string); ok {
-					anchor.DstPath = dstPath
-				}
-				if diffType, ok := anchorData["diff_type"].(string); ok {
-					anchor.DiffType = diffType
-				}
-				if orphanedType, ok := anchorData["orphaned_type"].(string); ok {
-					anchor.OrphanedType = orphanedType
-				}
+			if line, ok := anchorData["line"].(float64); ok {
+				anchor.Line = int(line)
+			}
+			if lineType, ok := anchorData["line_type"].(string); ok {
+				anchor.LineType = lineType
+			}
+			if path, ok := anchorData["path"].(string); ok {
+				anchor.Path = path
+			}
+			if fileType, ok := anchorData["file_type"].(string); ok {
+				anchor.FileType = fileType
+			}
+			if fromHash, ok := anchorData["from_hash"].(string); ok {
+				anchor.FromHash = fromHash
+			}
+			if toHash, ok := anchorData["to_hash"].(string); ok {
+				anchor.ToHash = toHash
+			}
+			if srcPath, ok := anchorData["src_path"].(string); ok {
+				anchor.SrcPath = srcPath
+			}
+			if dstPath, ok := anchorData["dst_path"].(string); ok {
+				anchor.DstPath = dstPath
+			}
+			if diffType, ok := anchorData["diff_type"].(string); ok {
+				anchor.DiffType = diffType
+			}
+			if orphanedType, ok := anchorData["orphaned_type"].(string); ok {
+				anchor.OrphanedType = orphanedType
+			}
 
-				// Auto-set diffType when commit hashes are provided but diffType is not specified
-				if (anchor.FromHash != "" || anchor.ToHash != "") && anchor.DiffType == "" {
-					anchor.DiffType = "RANGE"
-				}
+			// Auto-set diffType when commit hashes are provided but diffType is not specified
+			if (anchor.FromHash != "" || anchor.ToHash != "") && anchor.DiffType == "" {
+				anchor.DiffType = "RANGE"
+			}
+		}
+
+		// Check inline anchors against the pull request diff and fill in line_type and file_type.
+		// Anchors on a specific commit range cannot be checked against the effective diff.
+		if anchor != nil && anchor.Path != "" && anchor.Line > 0 && (anchor.DiffType == "" || anchor.DiffType == "EFFECTIVE") {
+			diff, err := bb.GetPullRequestStructuredDiff(projectKey, repoSlug, pullRequestID, 0, "", "", "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pull request diff to validate anchor: %w", err)
 			}
+			if err := diff.CompleteAnchor(anchor); err != nil {
+				return nil, toolErrorf("invalid comment anchor: %v", err)
+			}
+		}
+
+		comment, err := bb.CreatePullRequestComment(projectKey, repoSlug, pullRequestID, text, anchor, severity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pull request comment: %w", err)
+		}
+
+		return formatResult(comment, output)
+	}))
+}
+
+func RegisterUpdatePullRequestComment(s *server.MCPServer, bb *bitbucket.Server) {
+	updateCommentTool := mcp.NewTool("update_pull_request_comment",
+		mcp.WithDescription("Edit the text of a pull request comment (automatically fetches current version for optimistic locking)"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithNumber("comment_id",
+			mcp.Required(),
+			mcp.Description("The comment ID"),
+		),
+		mcp.WithString("text",
+			mcp.Required(),
+			mcp.Description("The new comment text"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Comment](),
+	)
+
+	s.AddTool(updateCommentTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		commentID := args.ID("comment_id")
+		text := args.RequiredString("text")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		// Get current comment to obtain the latest version for optimistic locking
+		currentComment, err := bb.GetPullRequestComment(projectKey, repoSlug, pullRequestID, commentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current comment version: %w", err)
+		}
+
+		comment, err := bb.UpdatePullRequestComment(projectKey, repoSlug, pullRequestID, commentID, currentComment.Version, text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update pull request comment: %w", err)
+		}
+
+		return formatResult(comment, output)
+	}))
+}
+
+func RegisterDeletePullRequestComment(s *server.MCPServer, bb *bitbucket.Server) {
+	deleteCommentTool := mcp.NewTool("delete_pull_request_comment",
+		mcp.WithDescription("Delete a pull request comment (automatically fetches current version for optimistic locking). Comments with replies cannot be deleted"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithNumber("comment_id",
+			mcp.Required(),
+			mcp.Description("The comment ID"),
+		),
+	)
+
+	s.AddTool(deleteCommentTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		commentID := args.ID("comment_id")
+		if err := args.Err(); err != nil {
+			return nil, err
 		}
 
-		comment, err := bb.CreatePullRequestComment(projectKey, repoSlug, int(pullRequestID), text, anchor)
+		// Get current comment to obtain the latest version for optimistic locking
+		currentComment, err := bb.GetPullRequestComment(projectKey, repoSlug, pullRequestID, commentID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create pull request comment: %v", err)
+			return nil, fmt.Errorf("failed to get current comment version: %w", err)
 		}
 
-		content, err := json.MarshalIndent(comment, "", "  ")
+		err = bb.DeletePullRequestComment(projectKey, repoSlug, pullRequestID, commentID, currentComment.Version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
+			return nil, fmt.Errorf("failed to delete pull request comment: %w", err)
 		}
 
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
 					Type: "text",
-					Text: string(content),
+					Text: "Pull request comment deleted successfully",
 				},
 			},
 		}, nil
-	})
+	}))
+}
+
+func RegisterResolvePullRequestComment(s *server.MCPServer, bb *bitbucket.Server) {
+	resolveTool := mcp.NewTool("resolve_pull_request_comment",
+		mcp.WithDescription("Resolve a pull request comment thread (Bitbucket 7.x+, automatically fetches current version for optimistic locking)"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithNumber("comment_id",
+			mcp.Required(),
+			mcp.Description("The ID of the root comment of the thread"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Comment](),
+	)
+
+	s.AddTool(resolveTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		return setPullRequestCommentState(request, bb, "RESOLVED")
+	}))
+}
+
+func RegisterReopenPullRequestComment(s *server.MCPServer, bb *bitbucket.Server) {
+	reopenTool := mcp.NewTool("reopen_pull_request_comment",
+		mcp.WithDescription("Reopen a resolved pull request comment thread (Bitbucket 7.x+, automatically fetches current version for optimistic locking)"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithNumber("comment_id",
+			mcp.Required(),
+			mcp.Description("The ID of the root comment of the thread"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Comment](),
+	)
+
+	s.AddTool(reopenTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		return setPullRequestCommentState(request, bb, "OPEN")
+	}))
+}
+
+// setPullRequestCommentState is shared by the resolve and reopen tools
+func setPullRequestCommentState(request mcp.CallToolRequest, bb *bitbucket.Server, state string) (*mcp.CallToolResult, error) {
+	args := parseArgs(request)
+
+	projectKey := args.ProjectKey(bb)
+	repoSlug := args.RequiredString("repo_slug")
+	pullRequestID := args.ID("pull_request_id")
+	commentID := args.ID("comment_id")
+	output := args.Output(formatJSON)
+	if err := args.Err(); err != nil {
+		return nil, err
+	}
+
+	// Get current comment to obtain the latest version for optimistic locking
+	currentComment, err := bb.GetPullRequestComment(projectKey, repoSlug, pullRequestID, commentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current comment version: %w", err)
+	}
+
+	comment, err := bb.SetPullRequestCommentState(projectKey, repoSlug, pullRequestID, commentID, currentComment.Version, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set comment state to %s: %w", state, err)
+	}
+
+	return formatResult(comment, output)
+}
+
+func RegisterCreatePullRequestTask(s *server.MCPServer, bb *bitbucket.Server) {
+	createTaskTool := mcp.NewTool("create_pull_request_task",
+		mcp.WithDescription("Create a task on a pull request. Uses blocker comments on Bitbucket 7.x+ and the legacy tasks API on older servers, where a comment_id is required"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithString("text",
+			mcp.Required(),
+			mcp.Description("The task text"),
+		),
+		mcp.WithNumber("comment_id",
+			mcp.Description("ID of the comment to attach the task to (required on Bitbucket 6.x and earlier)"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Task](),
+	)
+
+	s.AddTool(createTaskTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		text := args.RequiredString("text")
+		commentID := args.OptionalID("comment_id")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		blockerComments, err := bb.SupportsBlockerComments()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine Bitbucket version: %w", err)
+		}
+
+		var task *bitbucket.Task
+		if blockerComments {
+			comment, err := bb.CreatePullRequestBlockerComment(projectKey, repoSlug, pullRequestID, text, commentID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create pull request task: %w", err)
+			}
+			converted := blockerCommentToTask(*comment)
+			task = &converted
+		} else {
+			if commentID <= 0 {
+				return nil, toolErrorf("comment_id is required to create a task on Bitbucket versions before 7.0")
+			}
+			task, err = bb.CreateTask(commentID, text)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create pull request task: %w", err)
+			}
+		}
+
+		return formatResult(task, output)
+	}))
+}
+
+// taskListResult is returned by list_pull_request_tasks
+type taskListResult struct {
+	OpenCount int              `json:"openCount"`
+	Tasks     []bitbucket.Task `json:"tasks"`
+}
+
+func RegisterListPullRequestTasks(s *server.MCPServer, bb *bitbucket.Server) {
+	listTasksTool := mcp.NewTool("list_pull_request_tasks",
+		mcp.WithDescription("List tasks (blocker comments on Bitbucket 7.x+) on a pull request"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithString("state",
+			mcp.Description("Filter by task state (default: OPEN)"),
+			mcp.Enum("OPEN", "RESOLVED", "ALL"),
+			mcp.DefaultString("OPEN"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[taskListResult](),
+	)
+
+	s.AddTool(listTasksTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		state := args.Enum("state", "OPEN", "OPEN", "RESOLVED", "ALL")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		blockerComments, err := bb.SupportsBlockerComments()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine Bitbucket version: %w", err)
+		}
+
+		var tasks []bitbucket.Task
+		if blockerComments {
+			stateFilter := state
+			if stateFilter == "ALL" {
+				stateFilter = ""
+			}
+			comments, err := bb.GetPullRequestBlockerComments(projectKey, repoSlug, pullRequestID, stateFilter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pull request tasks: %w", err)
+			}
+			for _, comment := range comments {
+				tasks = append(tasks, blockerCommentToTask(comment))
+			}
+		} else {
+			allTasks, err := bb.GetPullRequestTasks(projectKey, repoSlug, pullRequestID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pull request tasks: %w", err)
+			}
+			// The legacy endpoint has no state filter
+			for _, task := range allTasks {
+				if state == "ALL" || task.State == state {
+					tasks = append(tasks, task)
+				}
+			}
+		}
+
+		openCount := 0
+		for _, task := range tasks {
+			if task.State == "OPEN" {
+				openCount++
+			}
+		}
+
+		result := taskListResult{OpenCount: openCount, Tasks: tasks}
+
+		return formatResult(result, output)
+	}))
+}
+
+func RegisterResolvePullRequestTask(s *server.MCPServer, bb *bitbucket.Server) {
+	resolveTaskTool := mcp.NewTool("resolve_pull_request_task",
+		mcp.WithDescription("Mark a pull request task as resolved (on Bitbucket 7.x+ the task ID is the blocker comment ID)"),
+		mcp.WithString("project_key",
+			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
+		),
+		mcp.WithString("repo_slug",
+			mcp.Required(),
+			mcp.Description("The repository slug"),
+		),
+		mcp.WithNumber("pull_request_id",
+			mcp.Required(),
+			mcp.Description("The pull request ID"),
+		),
+		mcp.WithNumber("task_id",
+			mcp.Required(),
+			mcp.Description("The task ID, as returned by list_pull_request_tasks"),
+		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.Task](),
+	)
+
+	s.AddTool(resolveTaskTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
+
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		pullRequestID := args.ID("pull_request_id")
+		taskID := args.ID("task_id")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
+			return nil, err
+		}
+
+		blockerComments, err := bb.SupportsBlockerComments()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine Bitbucket version: %w", err)
+		}
+
+		var task *bitbucket.Task
+		if blockerComments {
+			// Get current comment to obtain the latest version for optimistic locking
+			currentComment, err := bb.GetPullRequestComment(projectKey, repoSlug, pullRequestID, taskID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get current task version: %w", err)
+			}
+			comment, err := bb.SetPullRequestCommentState(projectKey, repoSlug, pullRequestID, taskID, currentComment.Version, "RESOLVED")
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve pull request task: %w", err)
+			}
+			converted := blockerCommentToTask(*comment)
+			task = &converted
+		} else {
+			task, err = bb.UpdateTaskState(taskID, "RESOLVED")
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve pull request task: %w", err)
+			}
+		}
+
+		return formatResult(task, output)
+	}))
+}
+
+// blockerCommentToTask presents a Bitbucket 7.x+ blocker comment in the same shape as a legacy task
+func blockerCommentToTask(comment bitbucket.Comment) bitbucket.Task {
+	task := bitbucket.Task{
+		ID:                  comment.ID,
+		Text:                comment.Text,
+		State:               comment.State,
+		Author:              comment.Author,
+		CreatedDate:         comment.CreatedDate,
+		PermittedOperations: comment.PermittedOperations,
+		Anchor: bitbucket.TaskAnchor{
+			ID:      comment.ID,
+			Version: comment.Version,
+		},
+	}
+	if comment.Anchor != nil {
+		task.Anchor.Path = comment.Anchor.Path
+		task.Anchor.Line = comment.Anchor.Line
+		task.Anchor.LineType = comment.Anchor.LineType
+		task.Anchor.FileType = comment.Anchor.FileType
+		task.Anchor.FromHash = comment.Anchor.FromHash
+		task.Anchor.ToHash = comment.Anchor.ToHash
+	}
+	return task
 }
 
 func RegisterGetRepos(s *server.MCPServer, bb *bitbucket.Server) {
@@ -621,51 +1358,37 @@ func RegisterGetRepos(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Description("The project key (optional if BITBUCKET_DEFAULT_PROJECT_KEY is set)"),
 		),
 		mcp.WithNumber("limit",
-			mcp.Description("Maximum number of results to return (default is 25)"),
+			mcp.Description("Maximum number of results to return (1-1000, default is 25)"),
 			mcp.DefaultNumber(25),
 		),
 		mcp.WithNumber("start",
 			mcp.Description("Starting index for pagination (default is 0)"),
 			mcp.DefaultNumber(0),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[valuesResult[bitbucket.Repository]](),
 	)
 
-	s.AddTool(getReposTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(getReposTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		limit := args.Int("limit", 25, 1, 1000)
+		start := args.Int("start", 0, 0, math.MaxInt32)
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		limit := 25
-		if limitVal, ok := args["limit"].(float64); ok {
-			limit = int(limitVal)
-		}
-
-		start := 0
-		if startVal, ok := args["start"].(float64); ok {
-			start = int(startVal)
-		}
 
 		repos, err := bb.GetRepos(projectKey, limit, start)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get repositories: %v", err)
-		}
-
-		content, err := json.MarshalIndent(repos, "", "  ")
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
+			return nil, fmt.Errorf("failed to get repositories: %w", err)
 		}
 
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+		return formatResult(repos, output, "slug", "name", "project")
+	}))
 }
 
 func RegisterGetPullRequestSettings(s *server.MCPServer, bb *bitbucket.Server) {
@@ -678,36 +1401,29 @@ func RegisterGetPullRequestSettings(s *server.MCPServer, bb *bitbucket.Server) {
 			mcp.Required(),
 			mcp.Description("The repository slug"),
 		),
+		withFormat(formatJSON),
+		withFields(),
+		withOutputSchema[bitbucket.PullRequestSettings](),
 	)
 
-	s.AddTool(getSettingsTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(getSettingsTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		bb := bb.ForContext(ctx)
+		args := parseArgs(request)
 
-		projectKey, err := getProjectKey(args, bb)
-		if err != nil {
+		projectKey := args.ProjectKey(bb)
+		repoSlug := args.RequiredString("repo_slug")
+		output := args.Output(formatJSON)
+		if err := args.Err(); err != nil {
 			return nil, err
 		}
-		repoSlug, _ := args["repo_slug"].(string)
 
 		settings, err := bb.GetPullRequestSettings(projectKey, repoSlug)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get pull request settings: %v", err)
+			return nil, fmt.Errorf("failed to get pull request settings: %w", err)
 		}
 
-		content, err := json.MarshalIndent(settings, "", "  ")
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal response: %v", err)
-		}
-
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: string(content),
-				},
-			},
-		}, nil
-	})
+		return formatResult(settings, output)
+	}))
 }
 
 func RegisterHelloWorld(s *server.MCPServer) {
@@ -719,12 +1435,12 @@ func RegisterHelloWorld(s *server.MCPServer) {
 		),
 	)
 
-	s.AddTool(helloTool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		args := request.GetArguments()
+	s.AddTool(helloTool, handle(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := parseArgs(request)
 
-		name := "World"
-		if nameVal, ok := args["name"].(string); ok && nameVal != "" {
-			name = nameVal
+		name := args.RequiredString("name")
+		if err := args.Err(); err != nil {
+			return nil, err
 		}
 
 		return &mcp.CallToolResult{
@@ -735,5 +1451,5 @@ func RegisterHelloWorld(s *server.MCPServer) {
 				},
 			},
 		}, nil
-	})
+	}))
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"bbcli/pkg/bitbucket"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// bitbucketTokenHeader carries a user's own Bitbucket personal access token on the sse and http
+// transports, so their actions are attributed to them and limited by their permissions. It is
+// used for the default profile; X-Bitbucket-Token-<profile> carries a token for a named profile.
+const bitbucketTokenHeader = "X-Bitbucket-Token"
+
+// shutdownTimeout bounds how long open requests and streams may take to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// sessionIdleTTL is how long a streamable HTTP session may go without requests before it is
+// ended. Clients that disconnect without a DELETE would otherwise keep their session, and its
+// resource subscriptions, forever.
+const sessionIdleTTL = 30 * time.Minute
+
+// heartbeatInterval keeps notification streams, and with them their sessions, alive while the
+// client listens
+const heartbeatInterval = time.Minute
+
+// serveOptions selects the transport and, for sse and http, how the server listens
+type serveOptions struct {
+	Transport string // stdio, sse or http
+	Listen    string
+	BaseURL   string // Public URL of the server, used in the SSE endpoint event
+	TLSCert   string
+	TLSKey    string
+	AuthToken string // Bearer token required on the MCP endpoints, from MCP_AUTH_TOKEN
+
+	AllowUnauthenticated bool // Serve on a non-loopback address without MCP_AUTH_TOKEN
+	RequireUserToken     bool // Refuse calls without X-Bitbucket-Token, from BITBUCKET_REQUIRE_USER_TOKEN
+}
+
+func parseServeOptions() serveOptions {
+	var opts serveOptions
+	flag.StringVar(&opts.Transport, "transport", "stdio", "Transport to serve MCP over: stdio, sse or http (streamable HTTP)")
+	flag.StringVar(&opts.Listen, "listen", "127.0.0.1:8080", "Address to listen on for the sse and http transports")
+	flag.StringVar(&opts.BaseURL, "base-url", "", "Public URL of the server, e.g. https://mcp.example.com, when behind a proxy (sse transport)")
+	flag.StringVar(&opts.TLSCert, "tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key")
+	flag.StringVar(&opts.TLSKey, "tls-key", "", "TLS private key file")
+	flag.BoolVar(&opts.AllowUnauthenticated, "allow-unauthenticated", false, "Serve on a non-loopback address without MCP_AUTH_TOKEN")
+	flag.Parse()
+
+	opts.AuthToken = os.Getenv("MCP_AUTH_TOKEN")
+	opts.RequireUserToken = isTruthy(os.Getenv("BITBUCKET_REQUIRE_USER_TOKEN"))
+
+	switch opts.Transport {
+	case "stdio", "sse", "http":
+	default:
+		log.Fatalf("Invalid -transport %q: use stdio, sse or http", opts.Transport)
+	}
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+	if opts.RequireUserToken && opts.Transport == "stdio" {
+		log.Printf("Warning: BITBUCKET_REQUIRE_USER_TOKEN only applies to the sse and http transports")
+	}
+
+	return opts
+}
+
+// serve runs the MCP server over the selected transport until ctx is cancelled
+func serve(ctx context.Context, s *server.MCPServer, opts serveOptions) error {
+	if opts.Transport == "stdio" {
+		err := server.NewStdioServer(s).Listen(ctx, os.Stdin, os.Stdout)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	if opts.AuthToken == "" && !isLoopback(opts.Listen) {
+		if !opts.AllowUnauthenticated {
+			return fmt.Errorf("MCP_AUTH_TOKEN is not set; anyone who can reach %s could use the configured Bitbucket credentials. Set it, listen on a loopback address or pass -allow-unauthenticated", opts.Listen)
+		}
+		log.Printf("Warning: MCP_AUTH_TOKEN is not set; anyone who can reach %s can use the configured Bitbucket credentials", opts.Listen)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealth)
+
+	// closeSessions ends the long-lived SSE streams, which would otherwise hold up the shutdown
+	closeSessions := func() {}
+	switch opts.Transport {
+	case "sse":
+		sseOptions := []server.SSEOption{
+			server.WithKeepAlive(true),
+			server.WithSSEContextFunc(bitbucketTokenContext(opts.RequireUserToken)),
+		}
+		if opts.BaseURL != "" {
+			sseOptions = append(sseOptions, server.WithBaseURL(opts.BaseURL))
+		} else {
+			sseOptions = append(sseOptions, server.WithUseFullURLForMessageEndpoint(false))
+		}
+		sseServer := server.NewSSEServer(s, sseOptions...)
+		mux.Handle("/sse", requireBearerToken(opts.AuthToken, sseServer))
+		mux.Handle("/message", requireBearerToken(opts.AuthToken, sseServer))
+		closeSessions = sseServer.CloseSessions
+	case "http":
+		httpServer := server.NewStreamableHTTPServer(s,
+			server.WithHTTPContextFunc(bitbucketTokenContext(opts.RequireUserToken)),
+			server.WithSessionIdleTTL(sessionIdleTTL),
+			server.WithHeartbeatInterval(heartbeatInterval),
+		)
+		mux.Handle("/mcp", requireBearerToken(opts.AuthToken, httpServer))
+	}
+
+	srv := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		scheme := "http"
+		var err error
+		if opts.TLSCert != "" {
+			scheme = "https"
+			log.Printf("Serving MCP over %s on %s://%s", opts.Transport, scheme, opts.Listen)
+			err = srv.ListenAndServeTLS(opts.TLSCert, opts.TLSKey)
+		} else {
+			log.Printf("Serving MCP over %s on %s://%s", opts.Transport, scheme, opts.Listen)
+			err = srv.ListenAndServe()
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down")
+	closeSessions()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Streams still open after the timeout are cut off
+		srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+// bitbucketTokenContext passes the tokens from the X-Bitbucket-Token and
+// X-Bitbucket-Token-<profile> headers on to the tools, which then call Bitbucket as that user.
+// Each token only reaches the instance it was sent for. With requireUserToken, calls without a
+// token fail instead of using the shared credentials.
+func bitbucketTokenContext(requireUserToken bool) func(ctx context.Context, r *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if requireUserToken {
+			ctx = bitbucket.ContextRequiringToken(ctx)
+		}
+		ctx = bitbucket.ContextWithToken(ctx, strings.TrimSpace(r.Header.Get(bitbucketTokenHeader)))
+		for key, values := range r.Header {
+			profile, ok := strings.CutPrefix(http.CanonicalHeaderKey(key), bitbucketTokenHeader+"-")
+			if ok && profile != "" && len(values) > 0 {
+				ctx = bitbucket.ContextWithProfileToken(ctx, profile, strings.TrimSpace(values[0]))
+			}
+		}
+		return ctx
+	}
+}
+
+// handleHealth reports that the process is up. It needs no token so load balancers can probe it.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"status":"ok"}`)
+}
+
+// requireBearerToken rejects requests without "Authorization: Bearer <token>". An empty token
+// leaves the handler unprotected.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(given)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bbcli"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}